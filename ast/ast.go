@@ -10,6 +10,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position // position of the first character belonging to the node
+	End() token.Position // position of the first character immediately after the node
 }
 
 type Statement interface {
@@ -24,6 +26,7 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	Comments   []token.Token // in source order; only populated when the lexer scans comments
 }
 
 func (p *Program) TokenLiteral() string {
@@ -34,6 +37,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if n := len(p.Statements); n > 0 {
+		return p.Statements[n-1].End()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out strings.Builder
 	for _, s := range p.Statements {
@@ -50,6 +67,8 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position  { return ls.Value.End() }
 func (ls *LetStatement) String() string {
 	var out strings.Builder
 	out.WriteString(ls.TokenLiteral() + " " + ls.Name.String() + " = ")
@@ -60,6 +79,23 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// FunctionStatement declares a named function: fn name(params) { ... }.
+// The function is bound when the enclosing block is entered, so it can be
+// called before its declaration.
+type FunctionStatement struct {
+	Token    token.Token // FUNCTION
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *FunctionStatement) End() token.Position  { return fs.Function.End() }
+func (fs *FunctionStatement) String() string {
+	return fs.TokenLiteral() + " " + fs.Name.String() + fs.Function.String()
+}
+
 type AssignStatement struct {
 	Token token.Token
 	Name  *Identifier
@@ -68,6 +104,8 @@ type AssignStatement struct {
 
 func (as *AssignStatement) statementNode()       {}
 func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssignStatement) Pos() token.Position  { return as.Name.Pos() }
+func (as *AssignStatement) End() token.Position  { return as.Value.End() }
 func (as *AssignStatement) String() string {
 	return fmt.Sprintf("%s = %s;", as.Name, as.Value)
 }
@@ -79,6 +117,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	var out strings.Builder
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -89,6 +134,56 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+type WhileStatement struct {
+	Token     token.Token // WHILE
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position  { return ws.Body.End() }
+func (ws *WhileStatement) String() string {
+	return "while " + ws.Condition.String() + " " + ws.Body.String()
+}
+
+// ForInStatement is a loop of the form for (x in xs) { ... }.
+type ForInStatement struct {
+	Token    token.Token // FOR
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForInStatement) End() token.Position  { return fs.Body.End() }
+func (fs *ForInStatement) String() string {
+	return "for " + fs.Variable.String() + " in " + fs.Iterable.String() + " " + fs.Body.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // BREAK
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+type ContinueStatement struct {
+	Token token.Token // CONTINUE
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+
 type ExpressionStatement struct {
 	Token      token.Token
 	Expression Expression
@@ -96,15 +191,24 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Expression.Pos() }
+func (es *ExpressionStatement) End() token.Position  { return es.Expression.End() }
 func (es *ExpressionStatement) String() string       { return es.Expression.String() }
 
 type BlockStatement struct {
 	Token      token.Token // {
 	Statements []Statement
+	Rbrace     token.Position // position of }
+
+	// NumSlots is set by the resolver to the number of variables the
+	// block declares. Blocks declaring none get no environment.
+	NumSlots int
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position  { return advance(bs.Rbrace, 1) }
 func (bs *BlockStatement) String() string {
 	var out strings.Builder
 	for _, stmt := range bs.Statements {
@@ -116,10 +220,19 @@ func (bs *BlockStatement) String() string {
 type Identifier struct {
 	Token token.Token
 	Value string
+
+	// Set by the resolver. A Local identifier refers to slot Slot of the
+	// environment Depth levels above the current one; other identifiers
+	// refer to globals, which are looked up by name.
+	Local bool
+	Depth int
+	Slot  int
 }
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 func (i *Identifier) String() string       { return i.Value }
 
 type IntegerLiteral struct {
@@ -129,6 +242,8 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 type StringLiteral struct {
@@ -138,6 +253,8 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 type Boolean struct {
@@ -147,6 +264,8 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type PrefixExpression struct {
@@ -157,6 +276,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return pe.Right.End() }
 func (pe *PrefixExpression) String() string {
 	var out strings.Builder
 	out.WriteByte('(')
@@ -175,6 +296,8 @@ type InfixExpression struct {
 
 func (oe *InfixExpression) expressionNode()      {}
 func (oe *InfixExpression) TokenLiteral() string { return oe.Token.Literal }
+func (oe *InfixExpression) Pos() token.Position  { return oe.Left.Pos() }
+func (oe *InfixExpression) End() token.Position  { return oe.Right.End() }
 func (oe *InfixExpression) String() string {
 	var out strings.Builder
 	out.WriteByte('(')
@@ -194,6 +317,13 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	return ie.Consequence.End()
+}
 func (ie *IfExpression) String() string {
 	var out strings.Builder
 
@@ -214,10 +344,15 @@ type FunctionLiteral struct {
 	Token      token.Token // FUNCTION
 	Parameters []*Identifier
 	Body       *BlockStatement
+	// Name is the name the function is declared or let-bound as, or
+	// empty for an anonymous function.
+	Name string
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position  { return fl.Body.End() }
 func (fl *FunctionLiteral) String() string {
 	var out strings.Builder
 
@@ -235,13 +370,16 @@ func (fl *FunctionLiteral) String() string {
 }
 
 type CallExpression struct {
-	Token     token.Token
+	Token     token.Token // (
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Position // position of )
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Function.Pos() }
+func (ce *CallExpression) End() token.Position  { return advance(ce.Rparen, 1) }
 func (ce *CallExpression) String() string {
 	var out strings.Builder
 
@@ -257,3 +395,69 @@ func (ce *CallExpression) String() string {
 
 	return out.String()
 }
+
+type ArrayLiteral struct {
+	Token    token.Token // [
+	Elements []Expression
+	Rbrack   token.Position // position of ]
+}
+
+func (al *ArrayLiteral) expressionNode()      {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return advance(al.Rbrack, 1) }
+func (al *ArrayLiteral) String() string {
+	elements := make([]string, len(al.Elements))
+	for i, e := range al.Elements {
+		elements[i] = e.String()
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+type IndexExpression struct {
+	Token  token.Token // [
+	Left   Expression
+	Index  Expression
+	Rbrack token.Position // position of ]
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Left.Pos() }
+func (ie *IndexExpression) End() token.Position  { return advance(ie.Rbrack, 1) }
+func (ie *IndexExpression) String() string {
+	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
+}
+
+type HashLiteral struct {
+	Token  token.Token    // {
+	Pairs  []HashPair     // in source order
+	Rbrace token.Position // position of }
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position  { return advance(hl.Rbrace, 1) }
+func (hl *HashLiteral) String() string {
+	pairs := make([]string, len(hl.Pairs))
+	for i, pair := range hl.Pairs {
+		pairs[i] = pair.Key.String() + ": " + pair.Value.String()
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// advance returns pos moved n bytes forward on the same line.
+func advance(pos token.Position, n int) token.Position {
+	if !pos.IsValid() {
+		return pos
+	}
+	pos.Offset += n
+	pos.Column += n
+	return pos
+}
@@ -0,0 +1,281 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses an AST in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w
+// for each of the non-nil children of node, in source order, followed by
+// a call of w.Visit(nil).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStatements(v, n.Statements)
+
+	case *LetStatement:
+		Walk(v, n.Name)
+		Walk(v, n.Value)
+
+	case *FunctionStatement:
+		Walk(v, n.Name)
+		Walk(v, n.Function)
+
+	case *AssignStatement:
+		Walk(v, n.Name)
+		Walk(v, n.Value)
+
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			Walk(v, n.ReturnValue)
+		}
+
+	case *WhileStatement:
+		Walk(v, n.Condition)
+		Walk(v, n.Body)
+
+	case *ForInStatement:
+		Walk(v, n.Variable)
+		Walk(v, n.Iterable)
+		Walk(v, n.Body)
+
+	case *ExpressionStatement:
+		Walk(v, n.Expression)
+
+	case *BlockStatement:
+		walkStatements(v, n.Statements)
+
+	case *BreakStatement, *ContinueStatement,
+		*Identifier, *IntegerLiteral, *StringLiteral, *Boolean:
+		// nothing to do
+
+	case *PrefixExpression:
+		Walk(v, n.Right)
+
+	case *InfixExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Right)
+
+	case *IfExpression:
+		Walk(v, n.Condition)
+		Walk(v, n.Consequence)
+		if n.Alternative != nil {
+			Walk(v, n.Alternative)
+		}
+
+	case *FunctionLiteral:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		Walk(v, n.Body)
+
+	case *CallExpression:
+		Walk(v, n.Function)
+		walkExpressions(v, n.Arguments)
+
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+
+	case *IndexExpression:
+		Walk(v, n.Left)
+		Walk(v, n.Index)
+
+	case *HashLiteral:
+		for _, pair := range n.Pairs {
+			Walk(v, pair.Key)
+			Walk(v, pair.Value)
+		}
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, exprs []Expression) {
+	for _, expr := range exprs {
+		Walk(v, expr)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses an AST in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a
+// call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// ModifierFunc returns the node to replace node with, or node itself to
+// keep it.
+type ModifierFunc func(node Node) Node
+
+// Modify rewrites an AST bottom-up: the children of node are modified
+// first and stored back into node, then node is replaced with
+// modifier(node), which Modify returns.
+//
+// Replacing a statement of a program or block with nil removes it, and
+// so does replacing the value of a ReturnStatement or the alternative of
+// an IfExpression. Any other replacement must have the type the field of
+// the parent allows; for example, a LetStatement's Name can only be
+// replaced with another *Identifier. Modify panics otherwise.
+//
+// Modify updates the nodes in place, so the resolution of identifiers
+// must be redone on the result.
+func Modify(node Node, modifier ModifierFunc) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Statements = modifyStatements(n.Statements, modifier)
+
+	case *LetStatement:
+		n.Name = modify(n.Name, modifier)
+		n.Value = modify(n.Value, modifier)
+
+	case *FunctionStatement:
+		n.Name = modify(n.Name, modifier)
+		n.Function = modify(n.Function, modifier)
+
+	case *AssignStatement:
+		n.Name = modify(n.Name, modifier)
+		n.Value = modify(n.Value, modifier)
+
+	case *ReturnStatement:
+		if n.ReturnValue != nil {
+			n.ReturnValue = modifyOptional(n.ReturnValue, modifier)
+		}
+
+	case *WhileStatement:
+		n.Condition = modify(n.Condition, modifier)
+		n.Body = modify(n.Body, modifier)
+
+	case *ForInStatement:
+		n.Variable = modify(n.Variable, modifier)
+		n.Iterable = modify(n.Iterable, modifier)
+		n.Body = modify(n.Body, modifier)
+
+	case *ExpressionStatement:
+		n.Expression = modify(n.Expression, modifier)
+
+	case *BlockStatement:
+		n.Statements = modifyStatements(n.Statements, modifier)
+
+	case *BreakStatement, *ContinueStatement,
+		*Identifier, *IntegerLiteral, *StringLiteral, *Boolean:
+		// nothing to do
+
+	case *PrefixExpression:
+		n.Right = modify(n.Right, modifier)
+
+	case *InfixExpression:
+		n.Left = modify(n.Left, modifier)
+		n.Right = modify(n.Right, modifier)
+
+	case *IfExpression:
+		n.Condition = modify(n.Condition, modifier)
+		n.Consequence = modify(n.Consequence, modifier)
+		if n.Alternative != nil {
+			n.Alternative = modifyOptional(n.Alternative, modifier)
+		}
+
+	case *FunctionLiteral:
+		for i, param := range n.Parameters {
+			n.Parameters[i] = modify(param, modifier)
+		}
+		n.Body = modify(n.Body, modifier)
+
+	case *CallExpression:
+		n.Function = modify(n.Function, modifier)
+		modifyExpressions(n.Arguments, modifier)
+
+	case *ArrayLiteral:
+		modifyExpressions(n.Elements, modifier)
+
+	case *IndexExpression:
+		n.Left = modify(n.Left, modifier)
+		n.Index = modify(n.Index, modifier)
+
+	case *HashLiteral:
+		for i := range n.Pairs {
+			pair := &n.Pairs[i]
+			pair.Key = modify(pair.Key, modifier)
+			pair.Value = modify(pair.Value, modifier)
+		}
+
+	default:
+		panic(fmt.Sprintf("ast.Modify: unexpected node type %T", n))
+	}
+
+	return modifier(node)
+}
+
+// modify modifies the child node and checks that the result can be
+// stored where node was.
+func modify[T Node](node T, modifier ModifierFunc) T {
+	result := Modify(node, modifier)
+	t, ok := result.(T)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: cannot replace %T with %T", node, result))
+	}
+	return t
+}
+
+// modifyOptional is like modify for a child that may be removed.
+func modifyOptional[T Node](node T, modifier ModifierFunc) T {
+	result := Modify(node, modifier)
+	if result == nil {
+		var zero T
+		return zero
+	}
+	t, ok := result.(T)
+	if !ok {
+		panic(fmt.Sprintf("ast.Modify: cannot replace %T with %T", node, result))
+	}
+	return t
+}
+
+func modifyStatements(stmts []Statement, modifier ModifierFunc) []Statement {
+	kept := stmts[:0]
+	for _, stmt := range stmts {
+		if result := Modify(stmt, modifier); result != nil {
+			s, ok := result.(Statement)
+			if !ok {
+				panic(fmt.Sprintf("ast.Modify: cannot replace %T with %T", stmt, result))
+			}
+			kept = append(kept, s)
+		}
+	}
+	clear(stmts[len(kept):])
+	return kept
+}
+
+func modifyExpressions(exprs []Expression, modifier ModifierFunc) {
+	for i, expr := range exprs {
+		exprs[i] = modify(expr, modifier)
+	}
+}
@@ -0,0 +1,217 @@
+package ast
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/pirosiki197/monkey/token"
+)
+
+// testProgram returns a program with every kind of node:
+//
+//	let f = fn(x) { if (!x) { return 1; } else { return; } };
+//	fn g() { while (true) { break; } }
+//	f = g;
+//	for (y in [1, "a"]) { continue; }
+//	f(2 + 3)[{4: 5}];
+func testProgram() *Program {
+	return &Program{Statements: []Statement{
+		&LetStatement{
+			Token: token.Token{Type: token.LET, Literal: "let"},
+			Name:  ident("f"),
+			Value: &FunctionLiteral{
+				Parameters: []*Identifier{ident("x")},
+				Body: block(expr(&IfExpression{
+					Condition:   &PrefixExpression{Operator: "!", Right: ident("x")},
+					Consequence: block(&ReturnStatement{Token: token.Token{Type: token.RETURN, Literal: "return"}, ReturnValue: integer(1)}),
+					Alternative: block(&ReturnStatement{Token: token.Token{Type: token.RETURN, Literal: "return"}}),
+				})),
+			},
+		},
+		&FunctionStatement{
+			Token: token.Token{Type: token.FUNCTION, Literal: "fn"},
+			Name:  ident("g"),
+			Function: &FunctionLiteral{
+				Body: block(&WhileStatement{
+					Condition: &Boolean{Token: token.Token{Type: token.TRUE, Literal: "true"}, Value: true},
+					Body:      block(&BreakStatement{}),
+				}),
+			},
+		},
+		&AssignStatement{Name: ident("f"), Value: ident("g")},
+		&ForInStatement{
+			Variable: ident("y"),
+			Iterable: &ArrayLiteral{Elements: []Expression{
+				integer(1),
+				&StringLiteral{Token: token.Token{Type: token.STRING, Literal: "a"}, Value: "a"},
+			}},
+			Body: block(&ContinueStatement{}),
+		},
+		expr(&IndexExpression{
+			Left: &CallExpression{
+				Function:  ident("f"),
+				Arguments: []Expression{&InfixExpression{Left: integer(2), Operator: "+", Right: integer(3)}},
+			},
+			Index: &HashLiteral{Pairs: []HashPair{{Key: integer(4), Value: integer(5)}}},
+		}),
+	}}
+}
+
+func ident(name string) *Identifier {
+	return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+}
+
+func integer(value int64) *IntegerLiteral {
+	return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: strconv.FormatInt(value, 10)}, Value: value}
+}
+
+func block(stmts ...Statement) *BlockStatement {
+	return &BlockStatement{Statements: stmts}
+}
+
+func expr(e Expression) *ExpressionStatement {
+	return &ExpressionStatement{Expression: e}
+}
+
+func TestInspect(t *testing.T) {
+	var (
+		visited []string
+		depth   int
+	)
+	Inspect(testProgram(), func(node Node) bool {
+		if node == nil {
+			depth--
+			return true
+		}
+		depth++
+		visited = append(visited, fmt.Sprintf("%T", node)[len("*ast."):])
+		return true
+	})
+
+	expected := []string{
+		"Program",
+		"LetStatement", "Identifier", "FunctionLiteral", "Identifier", "BlockStatement",
+		"ExpressionStatement", "IfExpression", "PrefixExpression", "Identifier",
+		"BlockStatement", "ReturnStatement", "IntegerLiteral",
+		"BlockStatement", "ReturnStatement",
+		"FunctionStatement", "Identifier", "FunctionLiteral", "BlockStatement",
+		"WhileStatement", "Boolean", "BlockStatement", "BreakStatement",
+		"AssignStatement", "Identifier", "Identifier",
+		"ForInStatement", "Identifier", "ArrayLiteral", "IntegerLiteral", "StringLiteral",
+		"BlockStatement", "ContinueStatement",
+		"ExpressionStatement", "IndexExpression", "CallExpression", "Identifier",
+		"InfixExpression", "IntegerLiteral", "IntegerLiteral",
+		"HashLiteral", "IntegerLiteral", "IntegerLiteral",
+	}
+	if !slices.Equal(visited, expected) {
+		t.Errorf("wrong nodes visited.\nexpected=%v\ngot=     %v", expected, visited)
+	}
+	if depth != 0 {
+		t.Errorf("f(nil) not called once per node. depth=%d", depth)
+	}
+}
+
+// identCounter counts the identifiers outside of function literals.
+type identCounter struct {
+	count int
+}
+
+func (c *identCounter) Visit(node Node) Visitor {
+	switch node.(type) {
+	case *FunctionLiteral:
+		return nil
+	case *Identifier:
+		c.count++
+	}
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	var c identCounter
+	Walk(&c, testProgram())
+
+	// f, g, f, g, y, f
+	if c.count != 6 {
+		t.Errorf("wrong number of identifiers. expected=6, got=%d", c.count)
+	}
+}
+
+func TestModify(t *testing.T) {
+	double := func(node Node) Node {
+		if il, ok := node.(*IntegerLiteral); ok {
+			return integer(il.Value * 2)
+		}
+		return node
+	}
+
+	program := testProgram()
+	if got := Modify(program, double); got != program {
+		t.Fatalf("Modify returned another node. got=%v", got)
+	}
+
+	var values []int64
+	Inspect(program, func(node Node) bool {
+		if il, ok := node.(*IntegerLiteral); ok {
+			values = append(values, il.Value)
+		}
+		return true
+	})
+	if expected := []int64{2, 2, 4, 6, 8, 10}; !slices.Equal(values, expected) {
+		t.Errorf("wrong integers after Modify. expected=%v, got=%v", expected, values)
+	}
+}
+
+func TestModifyRemove(t *testing.T) {
+	program := testProgram()
+	Modify(program, func(node Node) Node {
+		switch node := node.(type) {
+		case *BreakStatement, *AssignStatement:
+			return nil
+		case *BlockStatement:
+			if len(node.Statements) == 1 {
+				if rs, ok := node.Statements[0].(*ReturnStatement); ok && rs.ReturnValue == nil {
+					// only the alternative of the if expression
+					return nil
+				}
+			}
+		}
+		return node
+	})
+
+	var kinds []string
+	for _, stmt := range program.Statements {
+		kinds = append(kinds, fmt.Sprintf("%T", stmt))
+	}
+	expected := []string{"*ast.LetStatement", "*ast.FunctionStatement", "*ast.ForInStatement", "*ast.ExpressionStatement"}
+	if !slices.Equal(kinds, expected) {
+		t.Errorf("wrong statements. expected=%v, got=%v", expected, kinds)
+	}
+
+	fn := program.Statements[0].(*LetStatement).Value.(*FunctionLiteral)
+	if ie := fn.Body.Statements[0].(*ExpressionStatement).Expression.(*IfExpression); ie.Alternative != nil {
+		t.Errorf("alternative not removed. got=%v", ie.Alternative)
+	}
+	while := program.Statements[1].(*FunctionStatement).Function.Body.Statements[0].(*WhileStatement)
+	if len(while.Body.Statements) != 0 {
+		t.Errorf("break not removed. got=%v", while.Body.Statements)
+	}
+}
+
+func TestModifyWrongType(t *testing.T) {
+	defer func() {
+		r := recover()
+		if r != "ast.Modify: cannot replace *ast.Identifier with *ast.IntegerLiteral" {
+			t.Errorf("wrong panic. got=%v", r)
+		}
+	}()
+
+	// the name of a let statement must stay an identifier
+	Modify(testProgram(), func(node Node) Node {
+		if _, ok := node.(*Identifier); ok {
+			return integer(0)
+		}
+		return node
+	})
+}
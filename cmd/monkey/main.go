@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unique"
+
+	"github.com/pirosiki197/monkey"
+	"github.com/pirosiki197/monkey/compiler"
+	"github.com/pirosiki197/monkey/diag"
+	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/parser"
+	"github.com/pirosiki197/monkey/repl"
+	"github.com/pirosiki197/monkey/resolver"
+	"github.com/pirosiki197/monkey/vm"
+)
+
+const usage = `usage: monkey [FILE | -] [ARG...]
+       monkey run FILE [ARG...]
+       monkey -e EXPR [ARG...]
+       monkey build [-o OUTPUT] FILE
+       monkey disasm FILE
+       monkey repl
+
+Without arguments, monkey starts the interactive REPL.
+FILE "-" reads the script from standard input. The remaining
+arguments are available to the script as the array args.
+
+build compiles FILE to bytecode, written to OUTPUT or to FILE
+with its extension replaced by .mkc. Bytecode files can be
+executed like scripts. disasm prints the bytecode compiled from a
+source or bytecode FILE.
+`
+
+// Exit codes.
+const (
+	exitOK = iota
+	exitRuntimeError
+	exitUsage
+	exitParseError
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		return startRepl(stdin, stdout, stderr)
+	}
+
+	switch cmd := args[0]; cmd {
+	case "repl":
+		if len(args) != 1 {
+			return usageError(stderr, "repl takes no arguments")
+		}
+		return startRepl(stdin, stdout, stderr)
+	case "run":
+		if len(args) < 2 {
+			return usageError(stderr, "run requires a FILE")
+		}
+		return runFile(args[1], args[2:], stdin, stdout, stderr)
+	case "build":
+		return build(args[1:], stderr)
+	case "disasm":
+		if len(args) != 2 {
+			return usageError(stderr, "disasm requires exactly one FILE")
+		}
+		return disasm(args[1], stdout, stderr)
+	case "-e":
+		if len(args) < 2 {
+			return usageError(stderr, "-e requires an expression")
+		}
+		return execute("-e", args[1], args[2:], stdout, stderr, true)
+	case "-h", "-help", "--help", "help":
+		io.WriteString(stdout, usage)
+		return exitOK
+	default:
+		if strings.HasPrefix(cmd, "-") && cmd != "-" {
+			return usageError(stderr, "unknown flag "+cmd)
+		}
+		return runFile(cmd, args[1:], stdin, stdout, stderr)
+	}
+}
+
+func usageError(stderr io.Writer, msg string) int {
+	fmt.Fprintf(stderr, "monkey: %s\n%s", msg, usage)
+	return exitUsage
+}
+
+func startRepl(stdin io.Reader, stdout, stderr io.Writer) int {
+	user, err := user.Current()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitRuntimeError
+	}
+	fmt.Fprintf(stdout, "Hello, %s! This is the Monkey programming language!\n", user.Username)
+	fmt.Fprintln(stdout, "Feel free to type in commands")
+	repl.Start(stdin, stdout)
+	return exitOK
+}
+
+func runFile(filename string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var (
+		src []byte
+		err error
+	)
+	if filename == "-" {
+		filename = "<stdin>"
+		src, err = io.ReadAll(stdin)
+	} else {
+		src, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %v\n", err)
+		return exitRuntimeError
+	}
+	if compiler.IsBytecode(src) {
+		return executeBytecode(filename, src, args, stdout, stderr)
+	}
+	return execute(filename, string(src), args, stdout, stderr, false)
+}
+
+func build(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	output := fs.String("o", "", "output file")
+	if err := fs.Parse(args); err != nil {
+		return usageError(stderr, "build: "+err.Error())
+	}
+	if fs.NArg() != 1 {
+		return usageError(stderr, "build requires exactly one FILE")
+	}
+
+	filename := fs.Arg(0)
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mkc"
+	}
+
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %v\n", err)
+		return exitRuntimeError
+	}
+
+	bytecode, code := compileSource(filename, string(src), stderr)
+	if code != exitOK {
+		return code
+	}
+	data, err := bytecode.MarshalBinary()
+	if err == nil {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %v\n", err)
+		return exitRuntimeError
+	}
+	return exitOK
+}
+
+// execute evaluates src with args bound to the script arguments.
+// If printResult is set, the resulting value is written to stdout.
+func execute(filename, src string, args []string, stdout, stderr io.Writer, printResult bool) int {
+	prog, err := monkey.CompileFile(filename, src)
+	if perr, ok := err.(*monkey.ParseError); ok {
+		// the exit status reports the failure even if stderr is broken
+		_ = diag.RenderAll(stderr, perr.Diagnostics, src)
+		return exitParseError
+	}
+
+	result, err := prog.Run(context.Background(),
+		monkey.WithGlobals(map[string]object.Object{"args": scriptArgs(args)}),
+		monkey.WithStdout(stdout))
+	if perr, ok := err.(*monkey.ParseError); ok {
+		_ = diag.RenderAll(stderr, perr.Diagnostics, src)
+		return exitParseError
+	}
+	if rerr, ok := err.(*monkey.RuntimeError); ok {
+		fmt.Fprintln(stderr, "ERROR: "+rerr.Trace())
+		return exitRuntimeError
+	}
+	if printResult && result != object.NULL {
+		fmt.Fprintln(stdout, result.Inspect())
+	}
+	return exitOK
+}
+
+// disasm prints the disassembly of a source or bytecode file.
+func disasm(filename string, stdout, stderr io.Writer) int {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(stderr, "monkey: %v\n", err)
+		return exitRuntimeError
+	}
+
+	var (
+		bytecode = &compiler.Bytecode{}
+		src      string
+	)
+	if compiler.IsBytecode(data) {
+		if err := bytecode.UnmarshalBinary(data); err != nil {
+			fmt.Fprintf(stderr, "monkey: %s: %v\n", filename, err)
+			return exitRuntimeError
+		}
+	} else {
+		src = string(data)
+		var code int
+		if bytecode, code = compileSource(filename, src, stderr); code != exitOK {
+			return code
+		}
+	}
+
+	if err := compiler.Disassemble(stdout, bytecode, src); err != nil {
+		fmt.Fprintf(stderr, "monkey: %v\n", err)
+		return exitRuntimeError
+	}
+	return exitOK
+}
+
+// compileSource parses and compiles src, reporting errors to stderr.
+func compileSource(filename, src string, stderr io.Writer) (*compiler.Bytecode, int) {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	program := p.ParseProgram()
+	diags := p.Diagnostics()
+	if len(diags) == 0 {
+		for _, err := range resolver.Resolve(program, isPredeclared) {
+			diags = append(diags, err.Diagnostic())
+		}
+	}
+	if len(diags) != 0 {
+		// the exit status reports the failure even if stderr is broken
+		_ = diag.RenderAll(stderr, diags, src)
+		return nil, exitParseError
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		fmt.Fprintln(stderr, err)
+		return nil, exitParseError
+	}
+	return comp.Bytecode(), exitOK
+}
+
+// isPredeclared reports whether name is bound before a script starts: it
+// names a builtin or the script arguments.
+func isPredeclared(name string) bool {
+	return name == "args" || slices.ContainsFunc(object.StandardBuiltins(), func(b *object.Builtin) bool {
+		return b.Name == name
+	})
+}
+
+// executeBytecode runs the serialized bytecode in data on the virtual
+// machine, with args bound to the script arguments.
+func executeBytecode(filename string, data []byte, args []string, stdout, stderr io.Writer) int {
+	var bytecode compiler.Bytecode
+	if err := bytecode.UnmarshalBinary(data); err != nil {
+		fmt.Fprintf(stderr, "monkey: %s: %v\n", filename, err)
+		return exitRuntimeError
+	}
+
+	globals := make([]object.Object, vm.GlobalsSize)
+	if i := slices.Index(bytecode.GlobalNames, "args"); i >= 0 {
+		globals[i] = scriptArgs(args)
+	}
+
+	builtins := object.DefaultRegistry()
+	builtins.Add(object.Puts(stdout))
+	result := vm.NewWithGlobalsStore(&bytecode, globals, vm.WithBuiltins(builtins)).Run()
+	if errObj, ok := result.(*object.Error); ok {
+		fmt.Fprintln(stderr, errObj.Inspect())
+		return exitRuntimeError
+	}
+	return exitOK
+}
+
+func scriptArgs(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: unique.Make(arg)}
+	}
+	return &object.Array{Elements: elements}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pirosiki197/monkey/compiler"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.mk")
+	err := os.WriteFile(script, []byte("#!/usr/bin/env monkey\nlet n = len(args);\nif (n > 1) { 1 / 0 }\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"expr", []string{"-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{"expr_null", []string{"-e", "if (false) { 1 }"}, "", exitOK, "", ""},
+		{"expr_args", []string{"-e", "args[1]", "a", "b"}, "", exitOK, "b\n", ""},
+		{"expr_parse_error", []string{"-e", "let = 1"}, "", exitParseError, "", "-e:1:5: error: expected next token to be IDENT, got = instead\n    let = 1\n        ^\n"},
+		{"expr_runtime_error", []string{"-e", "1 / 0"}, "", exitRuntimeError, "", "ERROR: -e:1:1: division by zero\n"},
+		{
+			"expr_undefined",
+			[]string{"-e", "x + y"},
+			"",
+			exitParseError,
+			"",
+			"-e:1:1: error: identifier not found: x\n    x + y\n    ^\n-e:1:5: error: identifier not found: y\n",
+		},
+		{"expr_stack_trace", []string{"-e", "let f = fn() { 1 / 0 }; f()"}, "", exitRuntimeError, "", "ERROR: -e:1:16: division by zero\n    at f/0 (-e:1:25)\n"},
+		{"run_file", []string{"run", script, "x"}, "", exitOK, "", ""},
+		{"run_file_error", []string{"run", script, "x", "y"}, "", exitRuntimeError, "", "ERROR: " + script + ":3:14: division by zero\n"},
+		{"file_without_run", []string{script}, "", exitOK, "", ""},
+		{"stdin", []string{"-", "x"}, "args[0] + true", exitRuntimeError, "", "ERROR: <stdin>:1:1: type mismatch: STRING + BOOLEAN\n"},
+		{"missing_file", []string{"run", filepath.Join(dir, "missing.mk")}, "", exitRuntimeError, "", "no such file"},
+		{"run_without_file", []string{"run"}, "", exitUsage, "", "run requires a FILE"},
+		{"unknown_flag", []string{"-x"}, "", exitUsage, "", "unknown flag -x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+
+			if code != tt.wantCode {
+				t.Errorf("exit code wrong. want=%d, got=%d (stderr=%q)", tt.wantCode, code, stderr.String())
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("stdout wrong. want=%q, got=%q", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr wrong. want to contain %q, got=%q", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.mk")
+	err := os.WriteFile(script, []byte("let n = len(args);\nif (n > 1) { 1 / 0 }\nputs(n);\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.mk")
+	if err := os.WriteFile(bad, []byte("let = 1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	undefined := filepath.Join(dir, "undefined.mk")
+	if err := os.WriteFile(undefined, []byte("len(args) + n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.mkc")
+
+	var stderr bytes.Buffer
+	if code := run([]string{"build", "-o", out, script}, nil, io.Discard, &stderr); code != exitOK {
+		t.Fatalf("build failed with code %d: %s", code, stderr.String())
+	}
+	if code := run([]string{"build", script}, nil, io.Discard, &stderr); code != exitOK {
+		t.Fatalf("build failed with code %d: %s", code, stderr.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "script.mkc")); err != nil {
+		t.Fatalf("default output not written: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldVersion := filepath.Join(dir, "old.mkc")
+	data[len(compiler.Magic)+1]++
+	if err := os.WriteFile(oldVersion, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"run", []string{"run", out, "x"}, exitOK, "1\n", ""},
+		{"run_error", []string{"run", out, "x", "y"}, exitRuntimeError, "", "ERROR: " + script + ":2:14: division by zero\n"},
+		{"file_without_run", []string{out}, exitOK, "0\n", ""},
+		{"incompatible_version", []string{"run", oldVersion}, exitRuntimeError, "", "unsupported bytecode format version"},
+		{"parse_error", []string{"build", bad}, exitParseError, "", bad + ":1:5: error: expected next token to be IDENT"},
+		{"undefined", []string{"build", undefined}, exitParseError, "", undefined + ":1:13: error: identifier not found: n\n"},
+		{"missing_file", []string{"build"}, exitUsage, "", "build requires exactly one FILE"},
+		{"missing_output", []string{"build", "-o"}, exitUsage, "", "flag needs an argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(""), &stdout, &stderr)
+
+			if code != tt.wantCode {
+				t.Errorf("exit code wrong. want=%d, got=%d (stderr=%q)", tt.wantCode, code, stderr.String())
+			}
+			if stdout.String() != tt.wantStdout {
+				t.Errorf("stdout wrong. want=%q, got=%q", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr wrong. want to contain %q, got=%q", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestDisasm(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.mk")
+	if err := os.WriteFile(script, []byte("let n = len(args);\nn / 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "script.mkc")
+	if code := run([]string{"build", script}, nil, io.Discard, io.Discard); code != exitOK {
+		t.Fatalf("build failed with code %d", code)
+	}
+
+	tests := []struct {
+		name       string
+		file       string
+		wantStdout []string
+	}{
+		{"source", script, []string{"     2| n / 2", "  0016 OpDiv", "OpGetBuiltin 0         len"}},
+		{"bytecode", out, []string{"  0016 OpDiv                                           2:1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run([]string{"disasm", tt.file}, nil, &stdout, &stderr); code != exitOK {
+				t.Fatalf("exit code wrong. want=%d, got=%d (stderr=%q)", exitOK, code, stderr.String())
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout does not contain %q. got=\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package code
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Instructions is a sequence of encoded instructions. Each instruction is
+// an opcode byte followed by its operands in big-endian order.
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out strings.Builder
+
+	i := 0
+	for i < len(ins) {
+		instruction, err := ins.Decode(i)
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		fmt.Fprintf(&out, "%04d %s\n", i, instruction)
+		i += instruction.Width
+	}
+
+	return out.String()
+}
+
+// Instruction is a decoded instruction.
+type Instruction struct {
+	Op       Opcode
+	Def      *Definition
+	Operands []int
+	Width    int // encoded size in bytes, including the opcode
+}
+
+func (i Instruction) String() string {
+	return fmtInstruction(i.Def, i.Operands)
+}
+
+// Decode decodes the instruction at offset ip.
+func (ins Instructions) Decode(ip int) (Instruction, error) {
+	def, err := Lookup(ins[ip])
+	if err != nil {
+		return Instruction{}, err
+	}
+
+	width := 1
+	for _, w := range def.OperandWidths {
+		width += w
+	}
+	if ip+width > len(ins) {
+		return Instruction{}, fmt.Errorf("truncated %s at %d", def.Name, ip)
+	}
+
+	operands, _ := ReadOperands(def, ins[ip+1:])
+	return Instruction{Op: Opcode(ins[ip]), Def: def, Operands: operands, Width: width}, nil
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+	}
+
+	var out strings.Builder
+	out.WriteString(def.Name)
+	for _, o := range operands {
+		fmt.Fprintf(&out, " %d", o)
+	}
+	return out.String()
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+
+	OpMinus
+	OpBang
+
+	OpJump
+	OpJumpNotTruthy
+	OpJumpFalsyOrPop
+	OpJumpTruthyOrPop
+
+	OpGetGlobal
+	OpSetGlobal
+	OpAssignGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetCell
+	OpSetCell
+	OpGetFree
+	OpSetFree
+	OpGetBuiltin
+
+	OpEnterBlock
+	OpCaptureLocal
+	OpCaptureFree
+	OpClosure
+
+	OpCall
+	OpReturnValue
+	OpReturn
+
+	OpArray
+	OpHash
+	OpIndex
+
+	OpIter
+	OpIterNext
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	// OpConstant pushes the constant at the given index.
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd: {"OpAdd", []int{}},
+	OpSub: {"OpSub", []int{}},
+	OpMul: {"OpMul", []int{}},
+	OpDiv: {"OpDiv", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	// OpJumpFalsyOrPop and OpJumpTruthyOrPop implement && and ||: they
+	// jump keeping the top of the stack if it decides the result and pop
+	// it otherwise.
+	OpJumpFalsyOrPop:  {"OpJumpFalsyOrPop", []int{2}},
+	OpJumpTruthyOrPop: {"OpJumpTruthyOrPop", []int{2}},
+
+	OpGetGlobal: {"OpGetGlobal", []int{2}},
+	OpSetGlobal: {"OpSetGlobal", []int{2}},
+	// OpAssignGlobal is like OpSetGlobal but fails if the global has not
+	// been defined yet.
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{1}},
+	OpSetLocal:     {"OpSetLocal", []int{1}},
+	// OpGetCell and OpSetCell access a local slot holding a cell, used
+	// for locals captured by closures.
+	OpGetCell:    {"OpGetCell", []int{1}},
+	OpSetCell:    {"OpSetCell", []int{1}},
+	OpGetFree:    {"OpGetFree", []int{1}},
+	OpSetFree:    {"OpSetFree", []int{1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+
+	// OpEnterBlock puts fresh cells into the local slots listed in the
+	// given entry of the current function's block table.
+	OpEnterBlock: {"OpEnterBlock", []int{2}},
+	// OpCaptureLocal and OpCaptureFree push a cell itself rather than
+	// its value, as free variables for a following OpClosure.
+	OpCaptureLocal: {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:  {"OpCaptureFree", []int{1}},
+	// OpClosure wraps the compiled function constant at the first operand
+	// into a closure over the number of cells given by the second operand.
+	OpClosure: {"OpClosure", []int{2, 1}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+
+	// OpIter pops an array and stores it and a fresh index in the local
+	// slot given by the operand and the one after it. OpIterNext pushes
+	// the next element or jumps to the second operand when done.
+	OpIter:     {"OpIter", []int{1}},
+	OpIterNext: {"OpIterNext", []int{1, 2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// MaxOperand returns the largest value operand i of op can hold.
+func MaxOperand(op Opcode, i int) int {
+	return 1<<(8*definitions[op].OperandWidths[i]) - 1
+}
+
+// Make encodes an instruction. It panics if an operand does not fit in
+// its width; see MaxOperand.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		if o < 0 || o > MaxOperand(op, i) {
+			panic(fmt.Sprintf("code.Make: operand %d of %s out of range: %d", i, def.Name, o))
+		}
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction and reports how
+// many bytes they occupy.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
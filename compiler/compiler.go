@@ -0,0 +1,755 @@
+package compiler
+
+import (
+	"fmt"
+	"unique"
+
+	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/token"
+)
+
+// Compiler lowers an AST to bytecode for the vm package. The compiled
+// program behaves like the tree-walking evaluator: blocks have their own
+// scope, every execution of a block gets fresh bindings, closures share
+// the variables they capture, and names are looked up when they are used,
+// so a function may refer to a binding defined after it.
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// position of the node being compiled
+	pos token.Position
+
+	// err records the first operand that did not fit in its instruction,
+	// so emit needs no error result.
+	err error
+}
+
+type CompilationScope struct {
+	instructions code.Instructions
+	positions    []object.PosEntry
+	blocks       [][]int
+	loops        []*loop
+
+	// pending counts the values left on the stack by the enclosing
+	// expressions while a subexpression is compiled.
+	pending int
+}
+
+type loop struct {
+	continueTarget int
+	breaks         []int
+	// pending is the stack height the loop body starts at.
+	pending int
+}
+
+type Bytecode struct {
+	Main        *object.CompiledFunction
+	Constants   []object.Object
+	GlobalNames []string
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, b := range object.StandardBuiltins() {
+		symbolTable.DefineBuiltin(i, b.Name)
+	}
+
+	return NewWithState(symbolTable, []object.Object{})
+}
+
+// NewWithState returns a compiler that continues from the global symbols
+// and constants of a previous compilation, as needed by a REPL.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	return &Compiler{
+		constants:   constants,
+		symbolTable: s,
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	pos := c.pos
+	if p := node.Pos(); p.IsValid() {
+		c.pos = p
+	}
+	defer func() {
+		c.pos = pos
+		if err == nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		return c.compileProgram(node)
+
+	case *ast.ExpressionStatement:
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		return c.compileBlock(node, false)
+
+	case *ast.LetStatement:
+		symbol := c.symbolTable.declare(node.Name.Value)
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		symbol.defined = true
+		c.storeSymbol(symbol, code.OpSetGlobal)
+
+	case *ast.FunctionStatement:
+		symbol := c.symbolTable.declare(node.Name.Value)
+		symbol.defined = true
+		if err := c.compileFunction(node.Function); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol, code.OpSetGlobal)
+
+	case *ast.AssignStatement:
+		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		if !ok || symbol.Scope == BuiltinScope {
+			symbol = c.globals().declare(node.Name.Value)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(symbol, code.OpAssignGlobal)
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+
+	case *ast.ForInStatement:
+		return c.compileForIn(node)
+
+	case *ast.BreakStatement:
+		l, err := c.currentLoop()
+		if err != nil {
+			return err
+		}
+		c.popTo(l)
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		l, err := c.currentLoop()
+		if err != nil {
+			return err
+		}
+		c.popTo(l)
+		c.emit(code.OpJump, l.continueTarget)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		return c.compileInfix(node)
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.compileBlock(node.Consequence, true); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else if err := c.compileBlock(node.Alternative, true); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// Not bound yet; the VM reports the error if it is still
+			// unbound when the code runs.
+			symbol = c.globals().declare(node.Value)
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: unique.Make(node.Value)}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+			c.pushed(1)
+		}
+		c.pushed(-len(node.Elements))
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			c.pushed(1)
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+			c.pushed(1)
+		}
+		c.pushed(-len(node.Pairs) * 2)
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.pushed(1)
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.pushed(-1)
+		c.emit(code.OpIndex)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunction(node)
+
+	case *ast.CallExpression:
+		if len(node.Arguments) > 255 {
+			return fmt.Errorf("%s: too many arguments", c.pos)
+		}
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		c.pushed(1)
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+			c.pushed(1)
+		}
+		c.pushed(-1 - len(node.Arguments))
+		c.emit(code.OpCall, len(node.Arguments))
+
+	default:
+		return fmt.Errorf("%s: cannot compile %T", c.pos, node)
+	}
+
+	return nil
+}
+
+// compileProgram compiles the main function. The value of a final
+// expression statement is returned as the result of the program.
+func (c *Compiler) compileProgram(program *ast.Program) error {
+	c.symbolTable.nextSlot = 0
+	c.symbolTable.maxSlots = 0
+	c.predeclare(program.Statements)
+	if err := c.declareFunctions(program.Statements); err != nil {
+		return err
+	}
+
+	stmts := program.Statements
+	for i, stmt := range stmts {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			continue
+		}
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i == len(stmts)-1 {
+			if err := c.Compile(es.Expression); err != nil {
+				return err
+			}
+			c.pos = es.Pos()
+			c.emit(code.OpReturnValue)
+			return nil
+		}
+		if err := c.Compile(stmt); err != nil {
+			return err
+		}
+	}
+	c.emit(code.OpReturn)
+	return nil
+}
+
+// compileBlock compiles block in a new scope. If value is set, the value
+// of the block is left on the stack: the value of its final expression
+// statement, or null.
+func (c *Compiler) compileBlock(block *ast.BlockStatement, value bool) error {
+	blockIndex := -1
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	c.predeclare(block.Statements)
+	if len(c.symbolTable.locals) > 0 {
+		blockIndex = c.newBlock()
+	}
+	if err := c.declareFunctions(block.Statements); err != nil {
+		return err
+	}
+
+	stmts := block.Statements
+	for i, stmt := range stmts {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			continue
+		}
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && value && i == len(stmts)-1 {
+			if err := c.Compile(es.Expression); err != nil {
+				return err
+			}
+			c.leaveBlock(blockIndex)
+			return nil
+		}
+		if err := c.Compile(stmt); err != nil {
+			return err
+		}
+	}
+	if value {
+		c.emit(code.OpNull)
+	}
+
+	c.leaveBlock(blockIndex)
+	return nil
+}
+
+// predeclare declares the names bound by let statements and function
+// declarations in stmts so that functions defined earlier in the same
+// scope can refer to them.
+func (c *Compiler) predeclare(stmts []ast.Statement) {
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			c.symbolTable.declare(stmt.Name.Value)
+		case *ast.FunctionStatement:
+			c.symbolTable.declare(stmt.Name.Value)
+		}
+	}
+}
+
+// declareFunctions compiles the function declarations in stmts. They are
+// bound before the other statements of their scope run.
+func (c *Compiler) declareFunctions(stmts []ast.Statement) error {
+	for _, stmt := range stmts {
+		if fs, ok := stmt.(*ast.FunctionStatement); ok {
+			if err := c.Compile(fs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// newBlock adds an entry to the block table of the current function and
+// emits the instruction that creates its cells.
+func (c *Compiler) newBlock() int {
+	scope := &c.scopes[c.scopeIndex]
+	index := len(scope.blocks)
+	scope.blocks = append(scope.blocks, nil)
+	c.emit(code.OpEnterBlock, index)
+	return index
+}
+
+// leaveBlock closes the current block symbol table. Captured locals are
+// switched over to cells, which the block entry at blockIndex creates.
+func (c *Compiler) leaveBlock(blockIndex int) {
+	table := c.symbolTable
+	cells := c.captureLocals(table.locals)
+	if blockIndex >= 0 {
+		c.scopes[c.scopeIndex].blocks[blockIndex] = cells
+	}
+	table.leave()
+	c.symbolTable = table.Outer
+}
+
+// captureLocals rewrites the instructions using captured locals into
+// their cell variants and returns the slots of the captured locals.
+func (c *Compiler) captureLocals(locals []*Symbol) []int {
+	ins := c.currentInstructions()
+	var slots []int
+	for _, sym := range locals {
+		if !sym.captured {
+			continue
+		}
+		slots = append(slots, sym.Index)
+		for _, pos := range sym.uses {
+			switch code.Opcode(ins[pos]) {
+			case code.OpGetLocal:
+				ins[pos] = byte(code.OpGetCell)
+			case code.OpSetLocal:
+				ins[pos] = byte(code.OpSetCell)
+			}
+		}
+	}
+	return slots
+}
+
+func (c *Compiler) compileInfix(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	if node.Operator == "&&" || node.Operator == "||" {
+		op := code.OpJumpFalsyOrPop
+		if node.Operator == "||" {
+			op = code.OpJumpTruthyOrPop
+		}
+		jumpPos := c.emit(op, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	c.pushed(1)
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.pushed(-1)
+
+	switch node.Operator {
+	case "+":
+		c.emit(code.OpAdd)
+	case "-":
+		c.emit(code.OpSub)
+	case "*":
+		c.emit(code.OpMul)
+	case "/":
+		c.emit(code.OpDiv)
+	case "<":
+		c.emit(code.OpLessThan)
+	case ">":
+		c.emit(code.OpGreaterThan)
+	case "<=":
+		c.emit(code.OpLessEqual)
+	case ">=":
+		c.emit(code.OpGreaterEqual)
+	case "==":
+		c.emit(code.OpEqual)
+	case "!=":
+		c.emit(code.OpNotEqual)
+	default:
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	return nil
+}
+
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	l := c.enterLoop(start)
+	if err := c.compileBlock(node.Body, false); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	c.leaveLoop(l)
+	return nil
+}
+
+func (c *Compiler) compileForIn(node *ast.ForInStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+
+	// The loop variable lives in its own block, entered once per
+	// iteration, like the environment the evaluator creates for it.
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	iterSlot := c.symbolTable.allocSlot()
+	c.symbolTable.allocSlot()
+	if iterSlot > 255 {
+		return fmt.Errorf("%s: too many local variables", c.pos)
+	}
+	c.emit(code.OpIter, iterSlot)
+
+	next := len(c.currentInstructions())
+	iterNextPos := c.emit(code.OpIterNext, iterSlot, 9999)
+	blockIndex := c.newBlock()
+	variable := c.symbolTable.Define(node.Variable.Value)
+	c.storeSymbol(variable, code.OpSetGlobal)
+
+	l := c.enterLoop(next)
+	if err := c.compileBlock(node.Body, false); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, next)
+
+	operands := []int{iterSlot, len(c.currentInstructions())}
+	c.checkOperands(code.OpIterNext, operands)
+	c.replaceInstruction(iterNextPos, code.Make(code.OpIterNext, operands...))
+	c.leaveLoop(l)
+	c.leaveBlock(blockIndex)
+	return nil
+}
+
+func (c *Compiler) enterLoop(continueTarget int) *loop {
+	scope := &c.scopes[c.scopeIndex]
+	l := &loop{continueTarget: continueTarget, pending: scope.pending}
+	scope.loops = append(scope.loops, l)
+	return l
+}
+
+// leaveLoop points the break jumps of l after the loop.
+func (c *Compiler) leaveLoop(l *loop) {
+	end := len(c.currentInstructions())
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, end)
+	}
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+}
+
+// pushed records that n more values wait on the stack for the rest of an
+// expression to be compiled.
+func (c *Compiler) pushed(n int) {
+	c.scopes[c.scopeIndex].pending += n
+}
+
+// popTo pops the values that expressions inside the body of l left on
+// the stack, so break and continue can jump out of them.
+func (c *Compiler) popTo(l *loop) {
+	for range c.scopes[c.scopeIndex].pending - l.pending {
+		c.emit(code.OpPop)
+	}
+}
+
+func (c *Compiler) currentLoop() (*loop, error) {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil, fmt.Errorf("%s: break or continue is not in a loop", c.pos)
+	}
+	return loops[len(loops)-1], nil
+}
+
+func (c *Compiler) compileFunction(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+
+	if err := c.compileBlock(node.Body, true); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	table := c.symbolTable
+	cellParams := c.captureLocals(table.locals)
+	freeSymbols := table.FreeSymbols
+	numLocals := table.maxSlots
+	if numLocals > 256 {
+		return fmt.Errorf("%s: too many local variables", c.pos)
+	}
+	scope := c.leaveScope()
+
+	var freeNames []string
+	for _, s := range freeSymbols {
+		c.loadCell(s)
+		freeNames = append(freeNames, s.Name)
+	}
+	if len(freeSymbols) > 255 {
+		return fmt.Errorf("%s: too many free variables", c.pos)
+	}
+
+	compiledFn := &object.CompiledFunction{
+		Name:          node.Name,
+		Instructions:  scope.instructions,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		CellParams:    cellParams,
+		Blocks:        scope.blocks,
+		FreeNames:     freeNames,
+		Positions:     scope.positions,
+	}
+	c.emit(code.OpClosure, c.addConstant(compiledFn), len(freeSymbols))
+	return nil
+}
+
+func (c *Compiler) loadSymbol(s *Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		s.uses = append(s.uses, c.emit(code.OpGetLocal, s.Index))
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// storeSymbol pops the top of the stack into s, using globalOp for globals.
+func (c *Compiler) storeSymbol(s *Symbol, globalOp code.Opcode) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(globalOp, s.Index)
+	case LocalScope:
+		s.uses = append(s.uses, c.emit(code.OpSetLocal, s.Index))
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// loadCell pushes the cell of the captured symbol s.
+func (c *Compiler) loadCell(s *Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	}
+}
+
+func (c *Compiler) globals() *SymbolTable {
+	s := c.symbolTable
+	for s.kind != globalTable {
+		s = s.Outer
+	}
+	return s
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// emit appends an instruction and returns its offset. An operand that
+// does not fit in the instruction is recorded as the compile error and
+// encoded as 0.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+
+	if n := len(scope.positions); c.pos.IsValid() && (n == 0 || scope.positions[n-1].Pos != c.pos) {
+		scope.positions = append(scope.positions, object.PosEntry{Offset: pos, Pos: c.pos})
+	}
+	scope.instructions = append(scope.instructions, ins...)
+
+	return pos
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	copy(ins[pos:], newInstruction)
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	operands := []int{operand}
+	c.checkOperands(op, operands)
+	c.replaceInstruction(opPos, code.Make(op, operands...))
+}
+
+// checkOperands records an error for the operands of op that are out of
+// range and replaces them with 0.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	for i, o := range operands {
+		if o >= 0 && o <= code.MaxOperand(op, i) {
+			continue
+		}
+		if c.err == nil {
+			c.err = fmt.Errorf("%s: %s", c.pos, operandLimit(op, i))
+		}
+		operands[i] = 0
+	}
+}
+
+// operandLimit describes the limit exceeded by operand i of op.
+func operandLimit(op code.Opcode, i int) string {
+	switch op {
+	case code.OpConstant:
+		return "too many constants"
+	case code.OpClosure:
+		if i == 0 {
+			return "too many constants"
+		}
+		return "too many free variables"
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpAssignGlobal:
+		return "too many global variables"
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		return "too many free variables"
+	case code.OpCall:
+		return "too many arguments"
+	case code.OpArray, code.OpHash:
+		return "too many elements in literal"
+	case code.OpEnterBlock:
+		return "too many blocks in function"
+	case code.OpJump, code.OpJumpNotTruthy, code.OpJumpFalsyOrPop, code.OpJumpTruthyOrPop:
+		return "function too large: jump target out of range"
+	case code.OpIterNext:
+		if i == 1 {
+			return "function too large: jump target out of range"
+		}
+	}
+	return "too many local variables"
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() CompilationScope {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	scope := c.scopes[0]
+	globals := c.globals()
+	return &Bytecode{
+		Main: &object.CompiledFunction{
+			Instructions: scope.instructions,
+			NumLocals:    globals.maxSlots,
+			Blocks:       scope.blocks,
+			Positions:    scope.positions,
+		},
+		Constants:   c.constants,
+		GlobalNames: globals.GlobalNames(),
+	}
+}
@@ -0,0 +1,462 @@
+package compiler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/parser"
+)
+
+type compilerTestCase struct {
+	name                 string
+	input                string
+	expectedConstants    []any
+	expectedInstructions []code.Instructions
+}
+
+// compiledFunction describes an expected *object.CompiledFunction constant.
+type compiledFunction struct {
+	instructions []code.Instructions
+	numLocals    int
+	cellParams   []int
+	blocks       [][]int
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			name:              "add",
+			input:             "1 + 2",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name:              "pop",
+			input:             "1; 2;",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name:              "minus",
+			input:             "-1",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			name:              "if_without_else",
+			input:             "if (true) { 10 }; 3333;",
+			expectedConstants: []any{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 11),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name:              "logical_and",
+			input:             "true && false",
+			expectedConstants: []any{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpFalsyOrPop, 5),
+				code.Make(code.OpFalse),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			name:              "let",
+			input:             "let one = 1; let two = 2;",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpReturn),
+			},
+		},
+		{
+			name:              "assign",
+			input:             "let one = 1; one = 2; one",
+			expectedConstants: []any{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestBlockScopes(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			name:              "block_local",
+			input:             "if (true) { let a = 1; a }",
+			expectedConstants: []any{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 17),
+				code.Make(code.OpEnterBlock, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJump, 18),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			name:  "parameter",
+			input: "fn(a) { a }",
+			expectedConstants: []any{
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpReturnValue),
+					},
+					numLocals: 1,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name:  "captured",
+			input: "fn(a) { let b = 1; fn() { a + b } }",
+			expectedConstants: []any{
+				1,
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetFree, 1),
+						code.Make(code.OpAdd),
+						code.Make(code.OpReturnValue),
+					},
+				},
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpEnterBlock, 0),
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpSetCell, 1),
+						code.Make(code.OpCaptureLocal, 0),
+						code.Make(code.OpCaptureLocal, 1),
+						code.Make(code.OpClosure, 1, 2),
+						code.Make(code.OpReturnValue),
+					},
+					numLocals:  2,
+					cellParams: []int{0},
+					blocks:     [][]int{{1}},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			name:  "builtin",
+			input: "fn() { len([]) }",
+			expectedConstants: []any{
+				compiledFunction{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetBuiltin, 0),
+						code.Make(code.OpArray, 0),
+						code.Make(code.OpCall, 1),
+						code.Make(code.OpReturnValue),
+					},
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestSymbolResolution(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.declare("later")
+
+	fn := NewEnclosedSymbolTable(global)
+	fn.Define("b")
+	block := NewBlockSymbolTable(fn)
+	block.declare("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+		ok       bool
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}, true},
+		{"later", Symbol{Name: "later", Scope: GlobalScope, Index: 1}, true},
+		{"b", Symbol{Name: "b", Scope: LocalScope, Index: 0}, true},
+		// c is declared but not yet defined
+		{"c", Symbol{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sym, ok := block.Resolve(tt.name)
+			if ok != tt.ok {
+				t.Fatalf("Resolve(%q) ok=%t, want %t", tt.name, ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sym.Name != tt.expected.Name || sym.Scope != tt.expected.Scope || sym.Index != tt.expected.Index {
+				t.Errorf("Resolve(%q) = %+v, want %+v", tt.name, *sym, tt.expected)
+			}
+		})
+	}
+
+	nested := NewEnclosedSymbolTable(block)
+	sym, ok := nested.Resolve("c")
+	if !ok || sym.Scope != FreeScope {
+		t.Fatalf("pending local is not visible from a nested function. got=%+v", sym)
+	}
+	if c := block.store["c"]; !c.captured {
+		t.Errorf("local referenced from a nested function is not marked as captured")
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+			compiler := New()
+			if err := compiler.Compile(program); err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
+
+			bytecode := compiler.Bytecode()
+			testInstructions(t, tt.expectedInstructions, bytecode.Main.Instructions)
+			testConstants(t, tt.expectedConstants, bytecode.Constants)
+		})
+	}
+}
+
+func testInstructions(t *testing.T, expected []code.Instructions, actual code.Instructions) {
+	t.Helper()
+
+	concatted := concatInstructions(expected)
+	if !slices.Equal(actual, concatted) {
+		t.Errorf("wrong instructions.\nwant=\n%s\ngot=\n%s", concatted, actual)
+	}
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
+
+func testConstants(t *testing.T, expected []any, actual []object.Object) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(expected), len(actual))
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				t.Errorf("constant %d is not %d. got=%s", i, constant, actual[i].Inspect())
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value.Value() != constant {
+				t.Errorf("constant %d is not %q. got=%s", i, constant, actual[i].Inspect())
+			}
+		case compiledFunction:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d is not a function. got=%T", i, actual[i])
+				continue
+			}
+			testInstructions(t, constant.instructions, fn.Instructions)
+			if fn.NumLocals != constant.numLocals {
+				t.Errorf("constant %d has wrong NumLocals. want=%d, got=%d", i, constant.numLocals, fn.NumLocals)
+			}
+			if !slices.Equal(fn.CellParams, constant.cellParams) {
+				t.Errorf("constant %d has wrong CellParams. want=%v, got=%v", i, constant.cellParams, fn.CellParams)
+			}
+			if !slices.EqualFunc(fn.Blocks, constant.blocks, slices.Equal) {
+				t.Errorf("constant %d has wrong Blocks. want=%v, got=%v", i, constant.blocks, fn.Blocks)
+			}
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `let add = fn(a) { a + len("xy") };
+add(1)`
+
+	comp := New()
+	if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out strings.Builder
+	if err := Disassemble(&out, comp.Bytecode(), input); err != nil {
+		t.Fatalf("Disassemble: %s", err)
+	}
+
+	expected := `constants:
+     0  "xy"
+     1  fn #1
+     2  1
+
+main (locals=0):
+     1| let add = fn(a) { a + len("xy") };
+  0000 OpClosure 1 0          fn #1                    1:11
+  0004 OpSetGlobal 0          add                      1:1
+     2| add(1)
+  0007 OpGetGlobal 0          add                      2:1
+  0010 OpConstant 2           1                        2:5
+  0013 OpCall 1                                        2:1
+  0015 OpReturnValue                                   2:1
+
+fn #1 add (params=1 locals=1):
+     1| let add = fn(a) { a + len("xy") };
+  0000 OpGetLocal 0                                    1:19
+  0002 OpGetBuiltin 0         len                      1:23
+  0004 OpConstant 0           "xy"                     1:27
+  0007 OpCall 1                                        1:23
+  0009 OpAdd                                           1:19
+  0010 OpReturnValue                                   1:11
+`
+	if out.String() != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, out.String())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	constants := func(i int) string { return fmt.Sprintf("%d;", i) }
+	lets := func(i int) string { return "let " + name(i) + " = true;" }
+	uses := func(i int) string { return name(i) + ";" }
+	arguments := func(i int) string { return fmt.Sprintf("%d, ", i) }
+	trues := func(int) string { return "true;" }
+
+	tests := []struct {
+		name  string
+		input string
+		err   string // empty if the input compiles
+	}{
+		{"constants", repeat(65536, constants), ""},
+		{"too_many_constants", repeat(65537, constants), "too many constants"},
+		{"globals", repeat(65536, lets), ""},
+		{"too_many_globals", repeat(65537, lets), "too many global variables"},
+		{"jump", "if (true) {" + repeat(32000, trues) + "}", ""},
+		{"jump_too_far", "if (true) {" + repeat(33000, trues) + "}", "jump target out of range"},
+		{"locals", "fn() {" + repeat(256, lets) + "}", ""},
+		{"too_many_locals", "fn() {" + repeat(257, lets) + "}", "too many local variables"},
+		{"free_variables", "fn() {" + repeat(255, lets) + "fn() {" + repeat(255, uses) + "} }", ""},
+		{"too_many_free_variables", "fn() {" + repeat(256, lets) + "fn() {" + repeat(256, uses) + "} }", "too many free variables"},
+		{"arguments", "f(" + repeat(254, arguments) + "0)", ""},
+		{"too_many_arguments", "f(" + repeat(255, arguments) + "0)", "too many arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			if errs := p.Errors(); len(errs) > 0 {
+				t.Fatalf("parser errors: %v", errs[0])
+			}
+
+			err := New().Compile(program)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %s", err)
+			case tt.err != "" && err == nil:
+				t.Errorf("expected error %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("wrong error. want=%q, got=%q", tt.err, err)
+			}
+		})
+	}
+}
+
+// repeat concatenates the results of f for 0 to n-1.
+func repeat(n int, f func(i int) string) string {
+	var out strings.Builder
+	for i := range n {
+		out.WriteString(f(i))
+	}
+	return out.String()
+}
+
+// name returns a distinct identifier for each i, none of them a keyword.
+func name(i int) string {
+	s := ""
+	for {
+		s = string(rune('a'+i%26)) + s
+		if i /= 26; i == 0 {
+			return "v" + s
+		}
+	}
+}
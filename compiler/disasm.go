@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/object"
+)
+
+// Disassemble writes a listing of b to w: the constant pool, then the main
+// function and each function constant. Every instruction is printed with
+// its offset, its decoded operands, a note on what the operands refer to
+// and its source line and column. If src is not empty, each source line is
+// printed above the first instruction compiled from it.
+func Disassemble(w io.Writer, b *Bytecode, src string) error {
+	d := &disassembler{b: b}
+	if src != "" {
+		d.lines = strings.Split(src, "\n")
+	}
+
+	d.printf("constants:\n")
+	for i, c := range b.Constants {
+		d.printf("%6d  %s\n", i, d.constant(i, c))
+	}
+
+	d.printf("\nmain (locals=%d):\n", b.Main.NumLocals)
+	d.function(b.Main)
+	for i, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			d.printf("\nfn #%d", i)
+			if fn.Name != "" {
+				d.printf(" %s", fn.Name)
+			}
+			d.printf(" (params=%d locals=%d", fn.NumParameters, fn.NumLocals)
+			if len(fn.FreeNames) > 0 {
+				d.printf(" free=%s", strings.Join(fn.FreeNames, ","))
+			}
+			d.printf("):\n")
+			d.function(fn)
+		}
+	}
+
+	_, err := io.WriteString(w, d.out.String())
+	return err
+}
+
+type disassembler struct {
+	b     *Bytecode
+	lines []string
+	out   strings.Builder
+}
+
+func (d *disassembler) printf(format string, a ...any) {
+	fmt.Fprintf(&d.out, format, a...)
+}
+
+func (d *disassembler) function(fn *object.CompiledFunction) {
+	lastLine := 0
+	for ip := 0; ip < len(fn.Instructions); {
+		pos := fn.PosAt(ip)
+		if pos.Line != lastLine && pos.Line > 0 && pos.Line <= len(d.lines) {
+			d.printf("%6d| %s\n", pos.Line, strings.TrimSpace(d.lines[pos.Line-1]))
+		}
+		lastLine = pos.Line
+
+		ins, err := fn.Instructions.Decode(ip)
+		if err != nil {
+			d.printf("  %04d ERROR: %s\n", ip, err)
+			return
+		}
+
+		var where string
+		if pos.IsValid() {
+			where = fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+		}
+		line := fmt.Sprintf("  %04d %-22s %-24s %s", ip, ins, d.note(fn, ins), where)
+		d.printf("%s\n", strings.TrimRight(line, " "))
+		ip += ins.Width
+	}
+}
+
+// note describes what the operands of ins refer to.
+func (d *disassembler) note(fn *object.CompiledFunction, ins code.Instruction) string {
+	var operand int
+	if len(ins.Operands) > 0 {
+		operand = ins.Operands[0]
+	}
+
+	switch ins.Op {
+	case code.OpConstant:
+		if operand < len(d.b.Constants) {
+			return d.constant(operand, d.b.Constants[operand])
+		}
+	case code.OpClosure:
+		return fmt.Sprintf("fn #%d", operand)
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpAssignGlobal:
+		if operand < len(d.b.GlobalNames) {
+			return d.b.GlobalNames[operand]
+		}
+	case code.OpGetBuiltin:
+		if builtins := object.StandardBuiltins(); operand < len(builtins) {
+			return builtins[operand].Name
+		}
+	case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+		if operand < len(fn.FreeNames) {
+			return fn.FreeNames[operand]
+		}
+	case code.OpEnterBlock:
+		if operand < len(fn.Blocks) && len(fn.Blocks[operand]) > 0 {
+			return fmt.Sprintf("cells %v", fn.Blocks[operand])
+		}
+	}
+	return ""
+}
+
+func (d *disassembler) constant(i int, c object.Object) string {
+	switch c := c.(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", c.Value.Value())
+	case *object.CompiledFunction:
+		return fmt.Sprintf("fn #%d", i)
+	default:
+		return c.Inspect()
+	}
+}
@@ -0,0 +1,380 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unique"
+
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/token"
+)
+
+// Serialized bytecode starts with Magic followed by the format version as
+// a big-endian uint16. The rest of the file is a sequence of unsigned
+// varints (signed ones for integer constants) and length-prefixed
+// strings:
+//
+//	filename       source file name shared by all positions
+//	global names   count, then one string each
+//	constants      count, then a tag byte and the value for each
+//	main function  the top-level code, encoded like function constants
+//
+// A function holds its name, instructions, NumLocals, NumParameters,
+// CellParams, Blocks and FreeNames, followed by its line table: one
+// entry per position change with the offset delta, line, column and
+// source offset.
+const (
+	Magic         = "\x00MKC"
+	FormatVersion = 2
+)
+
+// Constant tags.
+const (
+	tagInteger byte = iota + 1
+	tagString
+	tagFunction
+)
+
+var (
+	ErrNotBytecode        = errors.New("not a monkey bytecode file")
+	ErrUnsupportedVersion = errors.New("unsupported bytecode format version")
+)
+
+// IsBytecode reports whether data starts with the bytecode magic header.
+func IsBytecode(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(Magic))
+}
+
+// MarshalBinary encodes the bytecode in the serialized format.
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	e := &encoder{filename: b.filename()}
+	e.buf = append(e.buf, Magic...)
+	e.buf = binary.BigEndian.AppendUint16(e.buf, FormatVersion)
+
+	e.string(e.filename)
+	e.strings(b.GlobalNames)
+
+	e.uint(len(b.Constants))
+	for i, c := range b.Constants {
+		switch c := c.(type) {
+		case *object.Integer:
+			e.buf = append(e.buf, tagInteger)
+			e.buf = binary.AppendVarint(e.buf, c.Value)
+		case *object.String:
+			e.buf = append(e.buf, tagString)
+			e.string(c.Value.Value())
+		case *object.CompiledFunction:
+			e.buf = append(e.buf, tagFunction)
+			e.function(c)
+		default:
+			return nil, fmt.Errorf("cannot encode constant %d of type %s", i, c.Type())
+		}
+	}
+
+	e.function(b.Main)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buf, nil
+}
+
+// filename returns the file name recorded in the positions of b.
+func (b *Bytecode) filename() string {
+	fns := []*object.CompiledFunction{b.Main}
+	for _, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			fns = append(fns, fn)
+		}
+	}
+	for _, fn := range fns {
+		for _, p := range fn.Positions {
+			if p.Pos.Filename != "" {
+				return p.Pos.Filename
+			}
+		}
+	}
+	return ""
+}
+
+type encoder struct {
+	buf      []byte
+	filename string
+	err      error
+}
+
+func (e *encoder) uint(n int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+func (e *encoder) ints(s []int) {
+	e.uint(len(s))
+	for _, n := range s {
+		e.uint(n)
+	}
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) strings(s []string) {
+	e.uint(len(s))
+	for _, str := range s {
+		e.string(str)
+	}
+}
+
+func (e *encoder) function(fn *object.CompiledFunction) {
+	e.string(fn.Name)
+	e.uint(len(fn.Instructions))
+	e.buf = append(e.buf, fn.Instructions...)
+	e.uint(fn.NumLocals)
+	e.uint(fn.NumParameters)
+	e.ints(fn.CellParams)
+	e.uint(len(fn.Blocks))
+	for _, block := range fn.Blocks {
+		e.ints(block)
+	}
+	e.strings(fn.FreeNames)
+
+	e.uint(len(fn.Positions))
+	offset := 0
+	for _, p := range fn.Positions {
+		if p.Pos.Filename != e.filename && e.err == nil {
+			e.err = fmt.Errorf("cannot encode positions from more than one file: %q and %q", e.filename, p.Pos.Filename)
+		}
+		e.uint(p.Offset - offset)
+		e.uint(p.Pos.Line)
+		e.uint(p.Pos.Column)
+		e.uint(p.Pos.Offset)
+		offset = p.Offset
+	}
+}
+
+// UnmarshalBinary decodes bytecode in the serialized format. It fails
+// with ErrNotBytecode or ErrUnsupportedVersion if data is not bytecode
+// of the current format version, and with an error of its own if the
+// functions have more locals than instructions can address or refer to
+// constants, globals or builtins that do not exist.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	if !IsBytecode(data) {
+		return ErrNotBytecode
+	}
+	data = data[len(Magic):]
+	if len(data) < 2 {
+		return fmt.Errorf("%w: truncated header", ErrNotBytecode)
+	}
+	if v := binary.BigEndian.Uint16(data); v != FormatVersion {
+		return fmt.Errorf("%w %d (want %d); rebuild the program", ErrUnsupportedVersion, v, FormatVersion)
+	}
+
+	d := &decoder{data: data[2:]}
+	d.filename = d.string()
+	globalNames := d.strings()
+
+	constants := make([]object.Object, d.len())
+	for i := range constants {
+		switch tag := d.byte(); tag {
+		case tagInteger:
+			constants[i] = &object.Integer{Value: d.varint()}
+		case tagString:
+			constants[i] = &object.String{Value: unique.Make(d.string())}
+		case tagFunction:
+			constants[i] = d.function()
+		default:
+			d.fail("unknown constant tag %d", tag)
+		}
+	}
+	main := d.function()
+
+	if d.err == nil && len(d.data) != 0 {
+		d.fail("%d bytes of trailing data", len(d.data))
+	}
+	for _, c := range append(constants, main) {
+		if fn, ok := c.(*object.CompiledFunction); ok && d.err == nil {
+			d.check(fn, constants, len(globalNames))
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	*b = Bytecode{Main: main, Constants: constants, GlobalNames: globalNames}
+	return nil
+}
+
+type decoder struct {
+	data     []byte
+	filename string
+	err      error
+}
+
+func (d *decoder) fail(format string, a ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("invalid bytecode: "+format, a...)
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uint() int {
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 || n > math.MaxInt32 {
+		d.fail("malformed number")
+		return 0
+	}
+	d.data = d.data[size:]
+	return int(n)
+}
+
+func (d *decoder) varint() int64 {
+	n, size := binary.Varint(d.data)
+	if size <= 0 {
+		d.fail("malformed number")
+		return 0
+	}
+	d.data = d.data[size:]
+	return n
+}
+
+// len reads a count of items that each take at least one byte, so that
+// corrupted counts cannot cause huge allocations.
+func (d *decoder) len() int {
+	n := d.uint()
+	if n > len(d.data) {
+		d.fail("count %d exceeds remaining data", n)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.len()
+	b := d.data[:n:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) ints() []int {
+	n := d.len()
+	if n == 0 {
+		return nil
+	}
+	s := make([]int, n)
+	for i := range s {
+		s[i] = d.uint()
+	}
+	return s
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *decoder) strings() []string {
+	n := d.len()
+	if n == 0 {
+		return nil
+	}
+	s := make([]string, n)
+	for i := range s {
+		s[i] = d.string()
+	}
+	return s
+}
+
+// maxLocals is the number of local slots instructions can address.
+const maxLocals = 256
+
+// check validates what the virtual machine relies on without checking
+// it as it runs: the number of locals of fn and the constants, globals
+// and builtins its instructions refer to.
+func (d *decoder) check(fn *object.CompiledFunction, constants []object.Object, numGlobals int) {
+	if fn.NumLocals > maxLocals {
+		d.fail("function %q has %d locals (at most %d)", fn.Name, fn.NumLocals, maxLocals)
+		return
+	}
+	if fn.NumParameters > fn.NumLocals {
+		d.fail("function %q has %d parameters but %d locals", fn.Name, fn.NumParameters, fn.NumLocals)
+		return
+	}
+
+	numBuiltins := len(object.StandardBuiltins())
+	for ip := 0; ip < len(fn.Instructions); {
+		ins, err := fn.Instructions.Decode(ip)
+		if err != nil {
+			d.fail("function %q: %v", fn.Name, err)
+			return
+		}
+		switch i := ins.Operands; ins.Op {
+		case code.OpConstant, code.OpClosure:
+			if i[0] >= len(constants) {
+				d.fail("function %q: constant %d out of range at %d", fn.Name, i[0], ip)
+				return
+			}
+			if _, ok := constants[i[0]].(*object.CompiledFunction); ins.Op == code.OpClosure && !ok {
+				d.fail("function %q: constant %d is not a function at %d", fn.Name, i[0], ip)
+				return
+			}
+		case code.OpGetGlobal, code.OpSetGlobal, code.OpAssignGlobal:
+			if i[0] >= numGlobals {
+				d.fail("function %q: global %d out of range at %d", fn.Name, i[0], ip)
+				return
+			}
+		case code.OpGetBuiltin:
+			if i[0] >= numBuiltins {
+				d.fail("function %q: builtin %d out of range at %d", fn.Name, i[0], ip)
+				return
+			}
+		}
+		ip += ins.Width
+	}
+}
+
+func (d *decoder) function() *object.CompiledFunction {
+	fn := &object.CompiledFunction{}
+	fn.Name = d.string()
+	fn.Instructions = d.bytes()
+	fn.NumLocals = d.uint()
+	fn.NumParameters = d.uint()
+	fn.CellParams = d.ints()
+	if n := d.len(); n > 0 {
+		fn.Blocks = make([][]int, n)
+		for i := range fn.Blocks {
+			fn.Blocks[i] = d.ints()
+		}
+	}
+	fn.FreeNames = d.strings()
+
+	if n := d.len(); n > 0 {
+		fn.Positions = make([]object.PosEntry, n)
+		offset := 0
+		for i := range fn.Positions {
+			offset += d.uint()
+			fn.Positions[i] = object.PosEntry{
+				Offset: offset,
+				Pos: token.Position{
+					Filename: d.filename,
+					Line:     d.uint(),
+					Column:   d.uint(),
+					Offset:   d.uint(),
+				},
+			}
+		}
+	}
+	return fn
+}
@@ -0,0 +1,124 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/parser"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	input := `
+let greet = fn(name) { "hello, " + name };
+let adder = fn(x) { fn(y) { x + y } };
+for (n in [1, -2]) { puts(greet("monkey"), adder(n)(40)) }
+undefinedName`
+
+	program := parser.New(lexer.NewWithFilename("test.mk", input)).ParseProgram()
+	comp := New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	want := comp.Bytecode()
+
+	data, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %s", err)
+	}
+	if !IsBytecode(data) {
+		t.Fatalf("encoded bytecode does not start with the magic header")
+	}
+
+	var got Bytecode
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %s", err)
+	}
+	if !reflect.DeepEqual(got.Main, want.Main) {
+		t.Errorf("main function differs.\nwant=%+v\ngot=%+v", want.Main, got.Main)
+	}
+	if !reflect.DeepEqual(got.GlobalNames, want.GlobalNames) {
+		t.Errorf("global names differ. want=%v, got=%v", want.GlobalNames, got.GlobalNames)
+	}
+	if len(got.Constants) != len(want.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(want.Constants), len(got.Constants))
+	}
+	for i := range want.Constants {
+		if want.Constants[i].Type() != got.Constants[i].Type() {
+			t.Errorf("constant %d has wrong type. want=%s, got=%s", i, want.Constants[i].Type(), got.Constants[i].Type())
+			continue
+		}
+		if !reflect.DeepEqual(got.Constants[i], want.Constants[i]) {
+			t.Errorf("constant %d differs.\nwant=%+v\ngot=%+v", i, want.Constants[i], got.Constants[i])
+		}
+	}
+}
+
+func TestBytecodeDecodeErrors(t *testing.T) {
+	comp := New()
+	if err := comp.Compile(parser.New(lexer.New("1 + 2")).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := comp.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %s", err)
+	}
+
+	newVersion := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(newVersion[len(Magic):], FormatVersion+1)
+
+	// corrupt encodes a main function with the given instructions and
+	// sizes, next to a single integer constant.
+	corrupt := func(numLocals, numParameters int, ins ...[]byte) []byte {
+		main := &object.CompiledFunction{NumLocals: numLocals, NumParameters: numParameters}
+		for _, i := range ins {
+			main.Instructions = append(main.Instructions, i...)
+		}
+		b := &Bytecode{Main: main, Constants: []object.Object{&object.Integer{Value: 1}}, GlobalNames: []string{"a"}}
+		data, err := b.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary: %s", err)
+		}
+		return data
+	}
+	var valid Bytecode
+	if err := valid.UnmarshalBinary(corrupt(256, 0, code.Make(code.OpConstant, 0), code.Make(code.OpGetGlobal, 0))); err != nil {
+		t.Fatalf("valid bytecode rejected: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"source", []byte("let a = 1;"), ErrNotBytecode},
+		{"version", newVersion, ErrUnsupportedVersion},
+		{"truncated", data[:len(data)-3], nil},
+		{"trailing", append(append([]byte(nil), data...), 0), nil},
+		{"too_many_locals", corrupt(257, 0), nil},
+		{"too_many_parameters", corrupt(1, 2), nil},
+		{"constant_index", corrupt(0, 0, code.Make(code.OpConstant, 1)), nil},
+		{"closure_of_integer", corrupt(0, 0, code.Make(code.OpClosure, 0, 0)), nil},
+		{"global_index", corrupt(0, 0, code.Make(code.OpSetGlobal, 1)), nil},
+		{"builtin_index", corrupt(0, 0, code.Make(code.OpGetBuiltin, 255)), nil},
+		{"unknown_opcode", corrupt(0, 0, []byte{255}), nil},
+		{"truncated_instruction", corrupt(0, 0, code.Make(code.OpConstant, 0)[:2]), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Bytecode
+			err := b.UnmarshalBinary(tt.data)
+			if err == nil {
+				t.Fatalf("no error for invalid data")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("wrong error. want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package compiler
+
+type SymbolScope int
+
+const (
+	GlobalScope SymbolScope = iota
+	LocalScope
+	BuiltinScope
+	FreeScope
+)
+
+func (s SymbolScope) String() string {
+	switch s {
+	case GlobalScope:
+		return "GLOBAL"
+	case LocalScope:
+		return "LOCAL"
+	case BuiltinScope:
+		return "BUILTIN"
+	case FreeScope:
+		return "FREE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+
+	// defined is set once the let statement binding the symbol has been
+	// compiled. Until then the symbol is only visible from nested
+	// functions, which run after the binding exists, matching the lookup
+	// rules of the tree-walking evaluator.
+	defined bool
+	// captured is set for locals referenced by a nested function.
+	// Such locals live in cells.
+	captured bool
+	// uses holds the offsets of the OpGetLocal and OpSetLocal
+	// instructions referring to a local, to be rewritten into their cell
+	// variants once the symbol turns out to be captured.
+	uses []int
+}
+
+type tableKind int
+
+const (
+	globalTable tableKind = iota
+	functionTable
+	blockTable
+)
+
+// SymbolTable maps names to symbols for one scope. The global table holds
+// the program-level bindings; each function literal gets a function table
+// holding its parameters and free variables; and each block gets a block
+// table whose locals share the slots of the enclosing function.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	kind     tableKind
+	store    map[string]*Symbol
+	builtins map[string]*Symbol
+
+	// globals, numGlobals: global table only
+	globals    []string
+	numGlobals int
+
+	// slot allocation for locals; owned by the global and function tables
+	base      int
+	nextSlot  int
+	maxSlots  int
+	slotOwner *SymbolTable
+
+	FreeSymbols []*Symbol
+
+	// locals defined in this table
+	locals []*Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := &SymbolTable{
+		kind:     globalTable,
+		store:    make(map[string]*Symbol),
+		builtins: make(map[string]*Symbol),
+	}
+	s.slotOwner = s
+	return s
+}
+
+// NewEnclosedSymbolTable returns the table of a function nested in outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := &SymbolTable{
+		Outer: outer,
+		kind:  functionTable,
+		store: make(map[string]*Symbol),
+	}
+	s.slotOwner = s
+	return s
+}
+
+// NewBlockSymbolTable returns the table of a block nested in outer.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	owner := outer.slotOwner
+	return &SymbolTable{
+		Outer:     outer,
+		kind:      blockTable,
+		store:     make(map[string]*Symbol),
+		base:      owner.nextSlot,
+		slotOwner: owner,
+	}
+}
+
+// leave releases the local slots of a block table for reuse.
+func (s *SymbolTable) leave() {
+	s.slotOwner.nextSlot = s.base
+}
+
+// Define binds name in this table, reusing the symbol if the name is
+// already bound here, and marks it as defined.
+func (s *SymbolTable) Define(name string) *Symbol {
+	sym := s.declare(name)
+	sym.defined = true
+	return sym
+}
+
+// declare binds name without making it visible to code in the same function.
+func (s *SymbolTable) declare(name string) *Symbol {
+	if sym, ok := s.store[name]; ok && sym.Scope != FreeScope {
+		return sym
+	}
+
+	sym := &Symbol{Name: name}
+	if s.kind == globalTable {
+		sym.Scope = GlobalScope
+		sym.Index = s.numGlobals
+		s.numGlobals++
+		s.globals = append(s.globals, name)
+	} else {
+		sym.Scope = LocalScope
+		sym.Index = s.allocSlot()
+		s.locals = append(s.locals, sym)
+	}
+	s.store[name] = sym
+	return sym
+}
+
+// allocSlot reserves a local slot that is not visible by name.
+func (s *SymbolTable) allocSlot() int {
+	owner := s.slotOwner
+	slot := owner.nextSlot
+	owner.nextSlot++
+	owner.maxSlots = max(owner.maxSlots, owner.nextSlot)
+	return slot
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) *Symbol {
+	sym := &Symbol{Name: name, Scope: BuiltinScope, Index: index, defined: true}
+	s.builtins[name] = sym
+	return sym
+}
+
+func (s *SymbolTable) defineFree(original *Symbol) *Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	sym := &Symbol{Name: original.Name, Scope: FreeScope, Index: len(s.FreeSymbols) - 1, defined: true}
+	s.store[original.Name] = sym
+	if original.Scope == LocalScope {
+		original.captured = true
+	}
+	return sym
+}
+
+// Resolve looks up name as seen by code in the current function.
+func (s *SymbolTable) Resolve(name string) (*Symbol, bool) {
+	return s.resolve(name, false)
+}
+
+// resolve looks up name; nested reports whether the reference is made
+// from a function nested inside the one owning s.
+func (s *SymbolTable) resolve(name string, nested bool) (*Symbol, bool) {
+	if s.kind == globalTable {
+		sym, ok := s.store[name]
+		if ok && sym.defined {
+			return sym, true
+		}
+		if builtin, ok := s.builtins[name]; ok {
+			return builtin, true
+		}
+		return sym, ok
+	}
+
+	if sym, ok := s.store[name]; ok && (sym.defined || nested) {
+		return sym, true
+	}
+
+	if s.kind == blockTable {
+		return s.Outer.resolve(name, nested)
+	}
+
+	sym, ok := s.Outer.resolve(name, true)
+	if !ok || sym.Scope == GlobalScope || sym.Scope == BuiltinScope {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
+
+// GlobalNames returns the names of the global slots, by index.
+func (s *SymbolTable) GlobalNames() []string {
+	return s.globals
+}
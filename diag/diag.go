@@ -0,0 +1,207 @@
+// Package diag describes problems found in Monkey source code, such as
+// syntax errors, and renders them together with the source they refer to.
+package diag
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pirosiki197/monkey/token"
+)
+
+// Severity tells how serious a diagnostic is.
+type Severity int
+
+const (
+	// Error is a problem that prevents the program from running.
+	Error Severity = iota
+	// Warning is a likely mistake that does not prevent the program from
+	// running.
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Code identifies the kind of a diagnostic, so tools can tell them apart
+// without matching messages.
+type Code string
+
+// Codes of the diagnostics reported by the lexer.
+const (
+	IllegalCharacter    Code = "illegal-character"
+	InvalidString       Code = "invalid-string"
+	UnterminatedComment Code = "unterminated-comment"
+)
+
+// Codes of the diagnostics reported by the parser.
+const (
+	UnexpectedToken Code = "unexpected-token"
+	InvalidInteger  Code = "invalid-integer"
+	BranchOutside   Code = "branch-outside-loop"
+	NestingTooDeep  Code = "nesting-too-deep"
+)
+
+// Codes of the diagnostics reported by static checks.
+const (
+	UndefinedIdentifier Code = "undefined-identifier"
+)
+
+// Diagnostic is a problem found in the source between Pos and End.
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Message  string
+	Pos      token.Position
+	End      token.Position // position immediately after the problem
+	Fix      *Fix           // suggested fix; nil if there is none
+}
+
+// Fix is an edit resolving a diagnostic: the source between Pos and End is
+// replaced with NewText. Pos and End are equal for an insertion.
+type Fix struct {
+	Message string // describes the edit, as in `insert ")"`
+	Pos     token.Position
+	End     token.Position
+	NewText string
+}
+
+// Error returns the message prefixed with the position, as in
+// "1:5: expected next token to be IDENT, got = instead".
+func (d *Diagnostic) Error() string {
+	return d.Pos.String() + ": " + d.Message
+}
+
+// maxLineWidth is the number of bytes of a source line Render shows at
+// most; longer lines are cut around the problem.
+const maxLineWidth = 100
+
+// maxDiagnostics is the number of diagnostics RenderAll renders at most.
+const maxDiagnostics = 10
+
+// Render writes d followed by the line of src it starts on, with the
+// problem underlined by carets and the suggested fix, if any:
+//
+//	1:5: error: expected next token to be IDENT, got = instead
+//	    let = 1;
+//	        ^
+//
+// Of a long line, only the part around the problem is shown, with "..."
+// marking the cuts. src must be the source the positions of d refer to.
+// The excerpt is left out if d has no valid position within src.
+func (d *Diagnostic) Render(w io.Writer, src string) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s: %s: %s\n", d.Pos, d.Severity, d.Message)
+	if line, ok := sourceLine(src, d.Pos); ok {
+		shown, shift, cut := window(line, d.Pos.Column)
+		pos, end := d.Pos, d.End
+		pos.Column -= shift
+		end.Column -= shift
+		out.WriteString("    ")
+		out.WriteString(shown)
+		if cut {
+			out.WriteString("...")
+		}
+		out.WriteString("\n    ")
+		out.WriteString(underline(shown, pos, end))
+		out.WriteByte('\n')
+	}
+	if d.Fix != nil {
+		fmt.Fprintf(&out, "    help: %s\n", d.Fix.Message)
+	}
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// RenderAll renders diags to w like Render. Only the first ten are
+// rendered; the rest are counted, as in "and 3 more errors".
+func RenderAll(w io.Writer, diags []*Diagnostic, src string) error {
+	for i, d := range diags {
+		if i == maxDiagnostics {
+			noun := "errors"
+			if len(diags)-i == 1 {
+				noun = "error"
+			}
+			_, err := fmt.Fprintf(w, "and %d more %s\n", len(diags)-i, noun)
+			return err
+		}
+		if err := d.Render(w, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourceLine returns the line of src containing pos, without the line
+// break.
+func sourceLine(src string, pos token.Position) (string, bool) {
+	if !pos.IsValid() || pos.Offset > len(src) || pos.Column < 1 {
+		return "", false
+	}
+	start := pos.Offset - (pos.Column - 1)
+	if start < 0 {
+		return "", false
+	}
+	line := src[start:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSuffix(line, "\r"), true
+}
+
+// window returns the part of line shown for a problem at column col: all
+// of line if it is short enough, and otherwise at most maxLineWidth bytes
+// around col, with "..." replacing the text cut from the start. shift is
+// the number of columns the problem moved left, and cut reports whether
+// text was cut from the end.
+func window(line string, col int) (shown string, shift int, cut bool) {
+	if len(line) <= maxLineWidth {
+		return line, 0, false
+	}
+	from := max(0, min(col-1-maxLineWidth/2, len(line)-maxLineWidth))
+	for from > 0 && !utf8.RuneStart(line[from]) {
+		from--
+	}
+	to := min(from+maxLineWidth, len(line))
+	for to < len(line) && !utf8.RuneStart(line[to]) {
+		to--
+	}
+	shown = line[from:to]
+	if from > 0 {
+		shown = "..." + shown
+		shift = from - len("...")
+	}
+	return shown, shift, to < len(line)
+}
+
+// underline returns the marker line placing carets below the part of line
+// between pos and end. Tabs in the indentation are kept so the carets
+// line up, and at least one caret is written. A problem continuing past
+// the line is underlined to its end.
+func underline(line string, pos, end token.Position) string {
+	from := min(pos.Column-1, len(line))
+	to := len(line)
+	if end.Line == pos.Line && end.Column > pos.Column {
+		to = min(end.Column-1, len(line))
+	}
+
+	var out strings.Builder
+	for _, r := range line[:from] {
+		if r == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	out.WriteString(strings.Repeat("^", max(1, len([]rune(line[from:to])))))
+	return out.String()
+}
@@ -0,0 +1,146 @@
+package diag
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pirosiki197/monkey/token"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		d        Diagnostic
+		expected string
+	}{
+		{
+			"single character",
+			"let = 1;",
+			Diagnostic{Message: "expected next token to be IDENT, got = instead",
+				Pos: pos(4, 1, 5), End: pos(5, 1, 6)},
+			"1:5: error: expected next token to be IDENT, got = instead\n" +
+				"    let = 1;\n" +
+				"        ^\n",
+		},
+		{
+			"span on a later line with tabs",
+			"let x = 1;\n\tputs(\"é\" & y);\n",
+			Diagnostic{Severity: Warning, Message: "non-ASCII string", Pos: pos(17, 2, 7), End: pos(21, 2, 11),
+				Fix: &Fix{Message: `replace with "e"`}},
+			"2:7: warning: non-ASCII string\n" +
+				"    \tputs(\"é\" & y);\n" +
+				"    \t     ^^^\n" +
+				"    help: replace with \"e\"\n",
+		},
+		{
+			"span past the end of the line",
+			"let s = `abc\ndef`;",
+			Diagnostic{Message: "bad string", Pos: pos(8, 1, 9), End: pos(17, 2, 5)},
+			"1:9: error: bad string\n" +
+				"    let s = `abc\n" +
+				"            ^^^^\n",
+		},
+		{
+			"at the end of the input",
+			"add(1,\n  2",
+			Diagnostic{Message: "expected next token to be ), got EOF instead",
+				Pos: pos(10, 2, 4), End: pos(10, 2, 4)},
+			"2:4: error: expected next token to be ), got EOF instead\n" +
+				"      2\n" +
+				"       ^\n",
+		},
+		{
+			"middle of a long line",
+			strings.Repeat("a", 200) + " @ " + strings.Repeat("b", 200),
+			Diagnostic{Message: `illegal character "@"`, Pos: pos(201, 1, 202), End: pos(202, 1, 203)},
+			"1:202: error: illegal character \"@\"\n" +
+				"    ..." + strings.Repeat("a", 49) + " @ " + strings.Repeat("b", 48) + "...\n" +
+				"    " + strings.Repeat(" ", 53) + "^\n",
+		},
+		{
+			"start of a long line",
+			"@ " + strings.Repeat("é", 100),
+			Diagnostic{Message: `illegal character "@"`, Pos: pos(0, 1, 1), End: pos(1, 1, 2)},
+			"1:1: error: illegal character \"@\"\n" +
+				"    @ " + strings.Repeat("é", 49) + "...\n" +
+				"    ^\n",
+		},
+		{
+			"end of a long line",
+			strings.Repeat("a", 200) + "\nb",
+			Diagnostic{Message: "expected ;", Pos: pos(200, 1, 201), End: pos(200, 1, 201)},
+			"1:201: error: expected ;\n" +
+				"    ..." + strings.Repeat("a", 100) + "\n" +
+				"    " + strings.Repeat(" ", 103) + "^\n",
+		},
+		{
+			"invalid position",
+			"1 + 2",
+			Diagnostic{Message: "something went wrong"},
+			"-: error: something went wrong\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := tt.d.Render(&out, tt.src); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%s: wrong output.\nexpected=%q\ngot=     %q", tt.name, tt.expected, out.String())
+		}
+	}
+}
+
+func TestRenderAll(t *testing.T) {
+	src := "1 @ 2"
+	d := &Diagnostic{Message: `illegal character "@"`, Pos: pos(2, 1, 3), End: pos(3, 1, 4)}
+	rendered := "1:3: error: illegal character \"@\"\n    1 @ 2\n      ^\n"
+
+	tests := []struct {
+		count    int
+		expected string
+	}{
+		{0, ""},
+		{10, strings.Repeat(rendered, 10)},
+		{11, strings.Repeat(rendered, 10) + "and 1 more error\n"},
+		{25, strings.Repeat(rendered, 10) + "and 15 more errors\n"},
+	}
+
+	for _, tt := range tests {
+		diags := make([]*Diagnostic, tt.count)
+		for i := range diags {
+			diags[i] = d
+		}
+		var out strings.Builder
+		if err := RenderAll(&out, diags, src); err != nil {
+			t.Fatalf("%d diagnostics: %v", tt.count, err)
+		}
+		if out.String() != tt.expected {
+			t.Errorf("%d diagnostics: wrong output.\nexpected=%q\ngot=     %q", tt.count, tt.expected, out.String())
+		}
+	}
+
+	if err := RenderAll(failingWriter{}, []*Diagnostic{d}, src); err != errWrite {
+		t.Errorf("write error not returned. got=%v", err)
+	}
+}
+
+var errWrite = errors.New("write failed")
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errWrite }
+
+func TestDiagnosticError(t *testing.T) {
+	d := &Diagnostic{Message: "oops", Pos: token.Position{Filename: "a.mk", Offset: 3, Line: 2, Column: 1}}
+	if got := d.Error(); got != "a.mk:2:1: oops" {
+		t.Errorf("wrong message. got=%q", got)
+	}
+}
+
+func pos(offset, line, column int) token.Position {
+	return token.Position{Offset: offset, Line: line, Column: column}
+}
@@ -1,31 +0,0 @@
-package evaluator
-
-import (
-	"fmt"
-
-	"github.com/pirosiki197/monkey/object"
-)
-
-var builtins = map[string]*object.Builtin{
-	"len": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. expected %d but got %d", 1, len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value.Value()))}
-			default:
-				return newError("argument to `len` not supported, got %s", arg.Type())
-			}
-		},
-	},
-	"puts": {
-		Fn: func(args ...object.Object) object.Object {
-			for _, arg := range args {
-				fmt.Println(arg.Inspect())
-			}
-			return NULL
-		},
-	},
-}
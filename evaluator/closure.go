@@ -0,0 +1,508 @@
+package evaluator
+
+import (
+	"unique"
+
+	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/token"
+)
+
+// frame holds the state of a running compiled function: the environment
+// of the innermost block being executed.
+type frame struct {
+	env *object.Environment
+}
+
+// evalFunc is an AST node compiled to a Go closure. It behaves exactly like
+// evaluating the node with the tree-walking evaluator, including the
+// evaluation steps it accounts for and the positions of the errors it
+// produces.
+type evalFunc func(f *frame) object.Object
+
+// run compiles node and runs it in e's environment.
+func (e *Evaluator) run(node ast.Node) object.Object {
+	if program, ok := node.(*ast.Program); ok {
+		if err := e.resolve(program); err != nil {
+			// The walker accounts for the program before resolving it.
+			if stepErr := e.enter(program.Pos()); stepErr != nil {
+				return stepErr
+			}
+			return err
+		}
+	}
+	return e.compile(node)(&frame{env: e.env})
+}
+
+// body returns the compiled body of a function, compiling it the first
+// time the function is called.
+func (s *state) body(block *ast.BlockStatement) evalFunc {
+	if fn, ok := s.bodies[block]; ok {
+		return fn
+	}
+	if s.bodies == nil {
+		s.bodies = make(map[*ast.BlockStatement]evalFunc)
+	}
+	fn := s.compile(block)
+	s.bodies[block] = fn
+	return fn
+}
+
+// enter accounts for the evaluation of the node at pos.
+func (s *state) enter(pos token.Position) *object.Error {
+	err := s.step()
+	if err != nil {
+		err.Pos = pos
+	}
+	return err
+}
+
+// tag sets the position of an error produced by the node at pos.
+func tag(pos token.Position, obj object.Object) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
+
+func (s *state) compile(node ast.Node) evalFunc {
+	switch node := node.(type) {
+	case *ast.Program:
+		return s.compileProgram(node)
+	case *ast.BlockStatement:
+		return s.compileBlock(node)
+	case *ast.LetStatement:
+		pos, name, value := node.Pos(), node.Name, s.compile(node.Value)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			val := value(f)
+			if isAbrupt(val) {
+				return val
+			}
+			bind(f.env, name, val)
+			return nil
+		}
+	case *ast.FunctionStatement:
+		pos, name, function := node.Pos(), node.Name, s.compile(node.Function)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			fn := function(f)
+			if isError(fn) {
+				return fn
+			}
+			bind(f.env, name, fn)
+			return nil
+		}
+	case *ast.AssignStatement:
+		pos, name, value := node.Pos(), node.Name, s.compile(node.Value)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			val := value(f)
+			if isAbrupt(val) {
+				return val
+			}
+			if name.Local {
+				f.env.SetAt(name.Depth, name.Slot, val)
+			} else if _, ok := f.env.Update(name.Value, val); !ok {
+				return tag(pos, newError("identifier not found: %s", name.Value))
+			}
+			return nil
+		}
+	case *ast.ReturnStatement:
+		pos, value := node.Pos(), s.compile(node.ReturnValue)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			val := value(f)
+			if isAbrupt(val) {
+				return val
+			}
+			return &object.ReturnValue{Value: val}
+		}
+	case *ast.WhileStatement:
+		return s.compileWhile(node)
+	case *ast.ForInStatement:
+		return s.compileForIn(node)
+	case *ast.BreakStatement:
+		return s.constant(node.Pos(), BREAK)
+	case *ast.ContinueStatement:
+		return s.constant(node.Pos(), CONTINUE)
+	case *ast.IfExpression:
+		return s.compileIf(node)
+	case *ast.ExpressionStatement:
+		pos, expr := node.Pos(), s.compile(node.Expression)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			return expr(f)
+		}
+	case *ast.PrefixExpression:
+		pos, operator, right := node.Pos(), node.Operator, s.compile(node.Right)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			r := right(f)
+			if isAbrupt(r) {
+				return r
+			}
+			return tag(pos, object.Prefix(operator, r))
+		}
+	case *ast.InfixExpression:
+		return s.compileInfix(node)
+	case *ast.CallExpression:
+		return s.compileCall(node)
+	case *ast.Identifier:
+		pos := node.Pos()
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			return tag(pos, s.evalIdentifier(f.env, node))
+		}
+	case *ast.IntegerLiteral:
+		return s.constant(node.Pos(), &object.Integer{Value: node.Value})
+	case *ast.StringLiteral:
+		return s.constant(node.Pos(), &object.String{Value: unique.Make(node.Value)})
+	case *ast.Boolean:
+		return s.constant(node.Pos(), nativeBoolToBooleanObject(node.Value))
+	case *ast.FunctionLiteral:
+		pos, name, params, body := node.Pos(), node.Name, node.Parameters, node.Body
+		s.body(body)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			return &object.Function{Name: name, Parameters: params, Env: f.env, Body: body}
+		}
+	case *ast.ArrayLiteral:
+		pos, elements := node.Pos(), s.compileAll(node.Elements)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			values, err := evalAll(f, elements)
+			if err != nil {
+				return err
+			}
+			return &object.Array{Elements: values}
+		}
+	case *ast.IndexExpression:
+		pos, left, index := node.Pos(), s.compile(node.Left), s.compile(node.Index)
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			l := left(f)
+			if isAbrupt(l) {
+				return l
+			}
+			i := index(f)
+			if isAbrupt(i) {
+				return i
+			}
+			return tag(pos, object.Index(l, i))
+		}
+	case *ast.HashLiteral:
+		return s.compileHash(node)
+	default:
+		// Like the tree walker, nodes without a value evaluate to nothing.
+		var pos token.Position
+		if node != nil {
+			pos = node.Pos()
+		}
+		return s.constant(pos, nil)
+	}
+}
+
+// constant compiles a node that always evaluates to obj. Literal objects
+// are allocated once, which is safe since objects are never mutated.
+func (s *state) constant(pos token.Position, obj object.Object) evalFunc {
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		return obj
+	}
+}
+
+func (s *state) compileProgram(program *ast.Program) evalFunc {
+	pos := program.Pos()
+	decls, stmts := s.compileStatements(program.Statements)
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		if err := runDeclarations(f, decls); err != nil {
+			return err
+		}
+		var result object.Object
+		for _, stmt := range stmts {
+			if stmt == nil {
+				result = nil
+				continue
+			}
+			result = stmt(f)
+
+			switch r := result.(type) {
+			case *object.ReturnValue:
+				return r.Value
+			case *object.Error:
+				return r
+			}
+		}
+		return result
+	}
+}
+
+func (s *state) compileBlock(block *ast.BlockStatement) evalFunc {
+	pos, numSlots := block.Pos(), block.NumSlots
+	decls, stmts := s.compileStatements(block.Statements)
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		if numSlots > 0 {
+			outer := f.env
+			f.env = object.NewEnclosedEnvironment(outer, numSlots)
+			defer func() { f.env = outer }()
+		}
+		if err := runDeclarations(f, decls); err != nil {
+			return err
+		}
+
+		var result object.Object
+		for _, stmt := range stmts {
+			if stmt == nil {
+				result = nil
+				continue
+			}
+			result = stmt(f)
+
+			if result != nil {
+				switch result.Type() {
+				case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+					return result
+				}
+			}
+		}
+		return result
+	}
+}
+
+func (s *state) compileWhile(ws *ast.WhileStatement) evalFunc {
+	pos, condition, body := ws.Pos(), s.compile(ws.Condition), s.compile(ws.Body)
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		for {
+			cond := condition(f)
+			if isAbrupt(cond) {
+				return cond
+			}
+			if !object.IsTruthy(cond) {
+				return nil
+			}
+
+			result, done := loopResult(body(f))
+			if done {
+				return result
+			}
+		}
+	}
+}
+
+func (s *state) compileForIn(fs *ast.ForInStatement) evalFunc {
+	pos, slot := fs.Pos(), fs.Variable.Slot
+	iterable, body := s.compile(fs.Iterable), s.compile(fs.Body)
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		it := iterable(f)
+		if isAbrupt(it) {
+			return it
+		}
+		array, ok := it.(*object.Array)
+		if !ok {
+			return tag(pos, newError("cannot iterate over %s", it.Type()))
+		}
+
+		outer := f.env
+		defer func() { f.env = outer }()
+		for _, elem := range array.Elements {
+			f.env = object.NewEnclosedEnvironment(outer, 1)
+			f.env.SetAt(0, slot, elem)
+
+			result, done := loopResult(body(f))
+			if done {
+				return result
+			}
+		}
+		return nil
+	}
+}
+
+func (s *state) compileIf(ie *ast.IfExpression) evalFunc {
+	pos, condition, consequence := ie.Pos(), s.compile(ie.Condition), s.compile(ie.Consequence)
+	var alternative evalFunc
+	if ie.Alternative != nil {
+		alternative = s.compile(ie.Alternative)
+	}
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		cond := condition(f)
+		if isAbrupt(cond) {
+			return cond
+		}
+		if object.IsTruthy(cond) {
+			return orNull(consequence(f))
+		}
+		if alternative == nil {
+			return NULL
+		}
+		return orNull(alternative(f))
+	}
+}
+
+func (s *state) compileInfix(node *ast.InfixExpression) evalFunc {
+	pos, operator := node.Pos(), node.Operator
+	left, right := s.compile(node.Left), s.compile(node.Right)
+
+	if operator == "&&" || operator == "||" {
+		return func(f *frame) object.Object {
+			if err := s.enter(pos); err != nil {
+				return err
+			}
+			l := left(f)
+			if isAbrupt(l) {
+				return l
+			}
+			if object.IsTruthy(l) == (operator == "||") {
+				return l
+			}
+			return right(f)
+		}
+	}
+
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		l := left(f)
+		if isAbrupt(l) {
+			return l
+		}
+		r := right(f)
+		if isAbrupt(r) {
+			return r
+		}
+		return tag(pos, s.checkSize(object.Infix(operator, l, r)))
+	}
+}
+
+func (s *state) compileCall(node *ast.CallExpression) evalFunc {
+	pos, function, arguments := node.Pos(), s.compile(node.Function), s.compileAll(node.Arguments)
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		fn := function(f)
+		if isAbrupt(fn) {
+			return fn
+		}
+		args, err := evalAll(f, arguments)
+		if err != nil {
+			return err
+		}
+		return tag(pos, unwrapReturnValue(s.applyFunction(fn, args, pos)))
+	}
+}
+
+func (s *state) compileHash(node *ast.HashLiteral) evalFunc {
+	type pair struct{ key, value evalFunc }
+	pos, pairs := node.Pos(), make([]pair, len(node.Pairs))
+	for i, p := range node.Pairs {
+		pairs[i] = pair{s.compile(p.Key), s.compile(p.Value)}
+	}
+	return func(f *frame) object.Object {
+		if err := s.enter(pos); err != nil {
+			return err
+		}
+		result := make(map[object.HashKey]object.HashPair, len(pairs))
+		for _, p := range pairs {
+			key := p.key(f)
+			if isAbrupt(key) {
+				return key
+			}
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return tag(pos, newError("unusable as hash key: %s", key.Type()))
+			}
+
+			value := p.value(f)
+			if isAbrupt(value) {
+				return value
+			}
+
+			result[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return &object.Hash{Pairs: result}
+	}
+}
+
+// compileStatements compiles the function declarations in stmts, which
+// are run first, separately from the other statements. The declarations
+// are left as nil entries in stmts.
+func (s *state) compileStatements(stmts []ast.Statement) (decls, compiled []evalFunc) {
+	compiled = make([]evalFunc, len(stmts))
+	for i, stmt := range stmts {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			decls = append(decls, s.compile(stmt))
+		} else {
+			compiled[i] = s.compile(stmt)
+		}
+	}
+	return decls, compiled
+}
+
+func runDeclarations(f *frame, decls []evalFunc) object.Object {
+	for _, decl := range decls {
+		if err := decl(f); isError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *state) compileAll(exps []ast.Expression) []evalFunc {
+	compiled := make([]evalFunc, len(exps))
+	for i, exp := range exps {
+		compiled[i] = s.compile(exp)
+	}
+	return compiled
+}
+
+// evalAll evaluates exps in order and stops at the first error.
+func evalAll(f *frame, exps []evalFunc) ([]object.Object, object.Object) {
+	result := make([]object.Object, 0, len(exps))
+	for _, exp := range exps {
+		evaluated := exp(f)
+		if isAbrupt(evaluated) {
+			return nil, evaluated
+		}
+		result = append(result, evaluated)
+	}
+	return result, nil
+}
@@ -1,91 +1,340 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"unique"
 
 	"github.com/pirosiki197/monkey/ast"
 	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/resolver"
+	"github.com/pirosiki197/monkey/token"
 )
 
+// DefaultMaxCallDepth is the maximum call depth used unless
+// WithMaxCallDepth is given.
+const DefaultMaxCallDepth = 10000
+
+// Errors wrapped by the error objects returned when evaluation is aborted.
+// Use errors.Is on the *object.Error to detect them.
+var (
+	ErrCancelled = errors.New("execution cancelled")
+	ErrStepLimit = errors.New("step limit exceeded")
+	ErrSizeLimit = errors.New("size limit exceeded")
+)
+
+// defaultBuiltins is used by the evaluators created without WithBuiltins.
+var defaultBuiltins = object.DefaultRegistry()
+
 type Evaluator struct {
 	env *object.Environment
+	*state
 }
 
-func New() *Evaluator {
-	return &Evaluator{
-		env: object.NewEnvironment(),
+// state is shared by an Evaluator and the evaluators it creates for
+// nested blocks and function calls.
+type state struct {
+	globals  *object.Environment
+	builtins *object.Registry
+
+	maxCallDepth int
+	callDepth    int
+	running      bool
+
+	ctx      context.Context
+	done     <-chan struct{}
+	maxSteps int
+	steps    int
+	maxSize  int
+
+	// closures selects the closure-compiling backend; bodies caches the
+	// compiled body of each function it has called.
+	closures bool
+	bodies   map[*ast.BlockStatement]evalFunc
+
+	// resolved is set when programs arrive already resolved.
+	resolved bool
+}
+
+// Option configures an Evaluator.
+type Option func(*state)
+
+// WithMaxCallDepth limits the depth of nested function calls.
+// Exceeding it produces an error object instead of overflowing the
+// Go stack. A value of zero or less disables the limit.
+func WithMaxCallDepth(depth int) Option {
+	return func(s *state) {
+		s.maxCallDepth = depth
+	}
+}
+
+// WithBuiltins makes the evaluator use the builtins of r instead of the
+// default ones. The evaluator does not modify r.
+func WithBuiltins(r *object.Registry) Option {
+	return func(s *state) {
+		s.builtins = r
+	}
+}
+
+// WithContext aborts evaluation once ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(s *state) {
+		s.ctx = ctx
+		s.done = ctx.Done()
+	}
+}
+
+// WithMaxSteps limits the number of nodes evaluated by each call to Eval.
+// A value of zero or less disables the limit.
+func WithMaxSteps(steps int) Option {
+	return func(s *state) {
+		s.maxSteps = steps
+	}
+}
+
+// WithMaxSize limits the size of the values a program creates: strings
+// to size bytes, and arrays and hashes to size elements. A value of zero
+// or less disables the limit.
+func WithMaxSize(size int) Option {
+	return func(s *state) {
+		s.maxSize = size
 	}
 }
 
-func NewWithEnv(env *object.Environment) *Evaluator {
+// WithClosureCompilation makes the evaluator translate each program into a
+// tree of Go closures once and run those instead of walking the AST. The
+// results are the same; repeated code such as loop and function bodies
+// runs faster.
+func WithClosureCompilation() Option {
+	return func(s *state) {
+		s.closures = true
+	}
+}
+
+// WithResolvedPrograms tells the evaluator that the programs it is given
+// have already been passed to resolver.Resolve and their unbound
+// identifiers checked. Eval then leaves the AST untouched, so the same
+// program can be run by several evaluators at once.
+func WithResolvedPrograms() Option {
+	return func(s *state) {
+		s.resolved = true
+	}
+}
+
+func New(opts ...Option) *Evaluator {
+	return NewWithEnv(object.NewEnvironment(), opts...)
+}
+
+func NewWithEnv(env *object.Environment, opts ...Option) *Evaluator {
+	s := &state{globals: env, builtins: defaultBuiltins, maxCallDepth: DefaultMaxCallDepth}
+	for _, opt := range opts {
+		opt(s)
+	}
 	return &Evaluator{
-		env: env,
+		env:   env,
+		state: s,
+	}
+}
+
+// withEnv returns an evaluator for env that shares e's state.
+func (e *Evaluator) withEnv(env *object.Environment) *Evaluator {
+	return &Evaluator{env: env, state: e.state}
+}
+
+func (e *Evaluator) Eval(node ast.Node) (obj object.Object) {
+	if !e.running {
+		// Outermost call: turn any panic into an error object so that a
+		// bug in a builtin or the evaluator cannot crash the host.
+		e.running = true
+		e.steps = 0
+		defer func() {
+			e.running = false
+			e.callDepth = 0
+			if r := recover(); r != nil {
+				obj = newError("internal error: %v", r)
+			}
+		}()
 	}
+
+	if e.closures {
+		obj = e.run(node)
+	} else if err := e.step(); err != nil {
+		obj = err
+	} else {
+		obj = e.eval(node)
+	}
+	// Errors are tagged with the position of the innermost node they
+	// were produced by; enclosing nodes leave it untouched.
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return obj
 }
 
-func (e *Evaluator) Eval(node ast.Node) object.Object {
+// Call calls fn, a function or builtin such as a callback a program passed
+// to the host, with args. It returns the value fn returns, or NULL if it
+// returns none; runtime errors are returned as an *object.Error.
+//
+// The call is subject to the evaluator's limits like a call to Eval, with
+// ctx instead of the context given by WithContext. Builtins may use Call
+// while a program is running, in which case the call shares the steps and
+// call depth of the running program.
+func (e *Evaluator) Call(ctx context.Context, fn object.Object, args ...object.Object) (result object.Object, err error) {
+	if !e.running {
+		e.running = true
+		e.steps = 0
+		defer func() {
+			e.running = false
+			e.callDepth = 0
+			if r := recover(); r != nil {
+				result, err = nil, newError("internal error: %v", r)
+			}
+		}()
+	}
+	ctxBefore, doneBefore := e.ctx, e.done
+	e.ctx, e.done = ctx, ctx.Done()
+	defer func() { e.ctx, e.done = ctxBefore, doneBefore }()
+
+	if errObj := e.step(); errObj != nil {
+		return nil, errObj
+	}
+	result = e.applyFunction(fn, args, token.Position{})
+	if errObj, ok := result.(*object.Error); ok {
+		return nil, errObj
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
+
+// step accounts for one evaluation step and reports an error if the
+// evaluation must be aborted.
+func (s *state) step() *object.Error {
+	s.steps++
+	if s.maxSteps > 0 && s.steps > s.maxSteps {
+		return &object.Error{Message: ErrStepLimit.Error(), Err: ErrStepLimit}
+	}
+	if s.done != nil {
+		select {
+		case <-s.done:
+			err := fmt.Errorf("%w: %w", ErrCancelled, s.ctx.Err())
+			return &object.Error{Message: err.Error(), Err: err}
+		default:
+		}
+	}
+	return nil
+}
+
+// checkSize returns obj, or an error if it is a string, array or hash
+// larger than the size limit. Only operators and builtins can create
+// values larger than the literals of the program, so their results are
+// checked.
+func (s *state) checkSize(obj object.Object) object.Object {
+	if s.maxSize <= 0 {
+		return obj
+	}
+	var size int
+	switch obj := obj.(type) {
+	case *object.String:
+		size = len(obj.Value.Value())
+	case *object.Array:
+		size = len(obj.Elements)
+	case *object.Hash:
+		size = len(obj.Pairs)
+	}
+	if size <= s.maxSize {
+		return obj
+	}
+	err := fmt.Errorf("%w: %s of size %d", ErrSizeLimit, obj.Type(), size)
+	return &object.Error{Message: err.Error(), Err: err}
+}
+
+func (e *Evaluator) eval(node ast.Node) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
+		if err := e.resolve(node); err != nil {
+			return err
+		}
 		return e.evalProgram(node)
 	case *ast.BlockStatement:
 		return e.evalBlockStatements(node)
 	case *ast.LetStatement:
 		val := e.Eval(node.Value)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
-		e.env.Set(node.Name.Value, val)
+		bind(e.env, node.Name, val)
+		return nil
+	case *ast.FunctionStatement:
+		fn := e.Eval(node.Function)
+		if isError(fn) {
+			return fn
+		}
+		bind(e.env, node.Name, fn)
 		return nil
 	case *ast.AssignStatement:
 		val := e.Eval(node.Value)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
-		_, ok := e.env.Update(node.Name.Value, val)
-		if !ok {
+		if node.Name.Local {
+			e.env.SetAt(node.Name.Depth, node.Name.Slot, val)
+		} else if _, ok := e.env.Update(node.Name.Value, val); !ok {
 			return newError("identifier not found: %s", node.Name.Value)
 		}
 		return nil
 	case *ast.ReturnStatement:
 		val := e.Eval(node.ReturnValue)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(node)
+	case *ast.ForInStatement:
+		return e.evalForInStatement(node)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.IfExpression:
 		return e.evalIfExpression(node)
 	case *ast.ExpressionStatement:
 		return e.Eval(node.Expression)
 	case *ast.PrefixExpression:
 		right := e.Eval(node.Right)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return object.Prefix(node.Operator, right)
 	case *ast.InfixExpression:
 		left := e.Eval(node.Left)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return e.evalLogicalExpression(node.Operator, left, node.Right)
+		}
 		right := e.Eval(node.Right)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return e.checkSize(object.Infix(node.Operator, left, right))
 	case *ast.CallExpression:
 		function := e.Eval(node.Function)
-		if isError(function) {
+		if isAbrupt(function) {
 			return function
 		}
 		args := e.evalExpressions(node.Arguments)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		evaluated := applyFunction(function, args)
+		evaluated := e.applyFunction(function, args, node.Pos())
 		return unwrapReturnValue(evaluated)
 	case *ast.Identifier:
-		return e.evalIdentifier(node)
+		return e.evalIdentifier(e.env, node)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 	case *ast.StringLiteral:
@@ -95,16 +344,87 @@ func (e *Evaluator) Eval(node ast.Node) object.Object {
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: e.env, Body: body}
+		return &object.Function{Name: node.Name, Parameters: params, Env: e.env, Body: body}
+	case *ast.ArrayLiteral:
+		elements := e.evalExpressions(node.Elements)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
+			return elements[0]
+		}
+		return &object.Array{Elements: elements}
+	case *ast.IndexExpression:
+		left := e.Eval(node.Left)
+		if isAbrupt(left) {
+			return left
+		}
+		index := e.Eval(node.Index)
+		if isAbrupt(index) {
+			return index
+		}
+		return object.Index(left, index)
+	case *ast.HashLiteral:
+		return e.evalHashLiteral(node)
 	default:
 		return nil
 	}
 }
 
+// resolve resolves the identifiers of program and reports those that are
+// not bound. Identifiers inside functions are left to be reported when the
+// function runs, since the program may bind them by then.
+func (e *Evaluator) resolve(program *ast.Program) *object.Error {
+	if e.resolved {
+		return nil
+	}
+	var errs []error
+	for _, err := range resolver.Resolve(program, e.isGlobal) {
+		if !err.Late {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	first := errs[0].(*resolver.Error)
+	msg := "identifier not found: " + first.Name
+	if len(errs) > 1 {
+		msg += fmt.Sprintf(" (and %d more errors)", len(errs)-1)
+	}
+	return &object.Error{Message: msg, Pos: first.Pos, Err: errors.Join(errs...)}
+}
+
+// bind binds a variable declared in the block whose environment is env.
+func bind(env *object.Environment, name *ast.Identifier, val object.Object) {
+	if name.Local {
+		env.SetAt(0, name.Slot, val)
+	} else {
+		env.Set(name.Value, val)
+	}
+}
+
+// declareFunctions binds the functions declared in stmts, before the
+// statements are evaluated.
+func (e *Evaluator) declareFunctions(stmts []ast.Statement) object.Object {
+	for _, stmt := range stmts {
+		if fs, ok := stmt.(*ast.FunctionStatement); ok {
+			if err := e.Eval(fs); isError(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (e *Evaluator) evalProgram(program *ast.Program) object.Object {
 	stmts := program.Statements
+	if err := e.declareFunctions(stmts); err != nil {
+		return err
+	}
 	var result object.Object
 	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			result = nil
+			continue
+		}
 		result = e.Eval(stmt)
 
 		switch result := result.(type) {
@@ -120,13 +440,23 @@ func (e *Evaluator) evalProgram(program *ast.Program) object.Object {
 func (e *Evaluator) evalBlockStatements(block *ast.BlockStatement) object.Object {
 	stmts := block.Statements
 	var result object.Object
-	enclosedEvaluator := &Evaluator{env: object.NewEnclosedEnvironment(e.env)}
+	enclosedEvaluator := e
+	if block.NumSlots > 0 {
+		enclosedEvaluator = e.withEnv(object.NewEnclosedEnvironment(e.env, block.NumSlots))
+	}
+	if err := enclosedEvaluator.declareFunctions(stmts); err != nil {
+		return err
+	}
 	for _, stmt := range stmts {
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			result = nil
+			continue
+		}
 		result = enclosedEvaluator.Eval(stmt)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -134,25 +464,100 @@ func (e *Evaluator) evalBlockStatements(block *ast.BlockStatement) object.Object
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (e *Evaluator) evalWhileStatement(ws *ast.WhileStatement) object.Object {
+	for {
+		condition := e.Eval(ws.Condition)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !object.IsTruthy(condition) {
+			return nil
+		}
+
+		result, done := loopResult(e.Eval(ws.Body))
+		if done {
+			return result
+		}
+	}
+}
+
+func (e *Evaluator) evalForInStatement(fs *ast.ForInStatement) object.Object {
+	iterable := e.Eval(fs.Iterable)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	array, ok := iterable.(*object.Array)
+	if !ok {
+		return newError("cannot iterate over %s", iterable.Type())
+	}
+
+	for _, elem := range array.Elements {
+		env := object.NewEnclosedEnvironment(e.env, 1)
+		env.SetAt(0, fs.Variable.Slot, elem)
+
+		result, done := loopResult(e.withEnv(env).Eval(fs.Body))
+		if done {
+			return result
+		}
+	}
+	return nil
+}
+
+// loopResult interprets the result of a loop body and reports whether
+// the loop is finished, together with the value the loop evaluates to.
+func loopResult(result object.Object) (object.Object, bool) {
+	if result == nil {
+		return nil, false
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	case object.BREAK_OBJ:
+		return nil, true
+	default:
+		return nil, false
+	}
+}
+
+// applyFunction calls fn with args. pos is the position of the call,
+// which is recorded in the stack of the errors coming out of fn.
+func (s *state) applyFunction(fn object.Object, args []object.Object, pos token.Position) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
 		if len(fn.Parameters) != len(args) {
-			return newError("wrong length of arguments: %d parameters but called with %d arguments",
-				len(fn.Parameters),
-				len(args))
+			return wrongArguments(fn.Name, len(fn.Parameters), len(args))
+		}
+		if s.maxCallDepth > 0 && s.callDepth >= s.maxCallDepth {
+			return newError("maximum call depth of %d exceeded", s.maxCallDepth)
 		}
+		s.callDepth++
+		defer func() { s.callDepth-- }()
+
 		extendedEnv := extendFunctionEnv(fn, args)
-		e := NewWithEnv(extendedEnv)
-		evaluated := e.Eval(fn.Body)
-		return unwrapReturnValue(evaluated)
+		var evaluated object.Object
+		if s.closures {
+			evaluated = s.body(fn.Body)(&frame{env: extendedEnv})
+		} else {
+			evaluated = (&Evaluator{env: extendedEnv, state: s}).Eval(fn.Body)
+		}
+		if err, ok := evaluated.(*object.Error); ok {
+			err.Stack = append(err.Stack, object.StackFrame{Function: fn.Name, Pos: pos, NumArgs: len(args)})
+		}
+		return orNull(unwrapReturnValue(evaluated))
 	case *object.Builtin:
-		return fn.Fn(args...)
+		return s.checkSize(fn.Call(args...))
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+func wrongArguments(name string, params, args int) *object.Error {
+	if name != "" {
+		return newError("wrong length of arguments to %s: %d parameters but called with %d arguments", name, params, args)
+	}
+	return newError("wrong length of arguments: %d parameters but called with %d arguments", params, args)
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -161,10 +566,10 @@ func unwrapReturnValue(obj object.Object) object.Object {
 }
 
 func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+	env := object.NewEnclosedEnvironment(fn.Env, len(fn.Parameters))
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		env.SetAt(0, param.Slot, args[paramIdx])
 	}
 
 	return env
@@ -175,7 +580,7 @@ func (e *Evaluator) evalExpressions(exps []ast.Expression) []object.Object {
 
 	for _, exp := range exps {
 		evaluated := e.Eval(exp)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -186,127 +591,96 @@ func (e *Evaluator) evalExpressions(exps []ast.Expression) []object.Object {
 
 func (e *Evaluator) evalIfExpression(ie *ast.IfExpression) object.Object {
 	condition := e.Eval(ie.Condition)
-	if isError(condition) {
+	if isAbrupt(condition) {
 		return condition
 	}
 
-	if isTruthy(condition) {
-		return e.Eval(ie.Consequence)
+	if object.IsTruthy(condition) {
+		return orNull(e.Eval(ie.Consequence))
 	} else if ie.Alternative != nil {
-		return e.Eval(ie.Alternative)
+		return orNull(e.Eval(ie.Alternative))
 	} else {
 		return NULL
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
-	switch operator {
-	case "!":
-		return evalBangOperatorExpression(right)
-	case "-":
-		return evalMinusOperatorExpression(right)
-	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
-	}
-}
-
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	switch {
-	case both(left, right, object.INTEGER_OBJ):
-		return evalIntegerInfixExpression(operator, left, right)
-	case both(left, right, object.STRING_OBJ):
-		return evalStringInfixExpression(operator, left, right)
-	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
-	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
-	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-}
-
-func evalIntegerInfixExpression(operator string, left object.Object, right object.Object) object.Object {
-	leftVal := left.(*object.Integer).Value
-	rightVal := right.(*object.Integer).Value
-	switch operator {
-	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
-	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
-	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
-	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
-	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
-	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
-	case "<=":
-		return nativeBoolToBooleanObject(leftVal <= rightVal)
-	case ">=":
-		return nativeBoolToBooleanObject(leftVal >= rightVal)
-	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+// orNull returns obj, or NULL if obj is nil: the value of a block or
+// function body without a final expression.
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return NULL
 	}
+	return obj
 }
 
-func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	leftVal := left.(*object.String).Value
-	rightVal := right.(*object.String).Value
-
-	switch operator {
-	case "+":
-		return &object.String{Value: unique.Make(leftVal.Value() + rightVal.Value())}
-	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
-	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+// evalLogicalExpression returns left if it decides the result of the
+// operator and the value of the unevaluated right operand otherwise.
+func (e *Evaluator) evalLogicalExpression(operator string, left object.Object, right ast.Expression) object.Object {
+	if object.IsTruthy(left) == (operator == "||") {
+		return left
 	}
+	return e.Eval(right)
 }
 
-func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
-}
+func (e *Evaluator) evalHashLiteral(node *ast.HashLiteral) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair, len(node.Pairs))
+
+	for _, p := range node.Pairs {
+		key := e.Eval(p.Key)
+		if isAbrupt(key) {
+			return key
+		}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
 
-func evalMinusOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+		value := e.Eval(p.Value)
+		if isAbrupt(value) {
+			return value
+		}
+
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+
+	return &object.Hash{Pairs: pairs}
 }
 
-func (e *Evaluator) evalIdentifier(node *ast.Identifier) object.Object {
-	if val, ok := e.env.Get(node.Value); ok {
+func (s *state) evalIdentifier(env *object.Environment, node *ast.Identifier) object.Object {
+	if node.Local {
+		// The slot is empty if a function refers to a variable of an
+		// enclosing block before the block has defined it.
+		if val, ok := env.GetAt(node.Depth, node.Slot); ok {
+			return val
+		}
+		return newError("identifier not found: %s", node.Value)
+	}
+	// Globals are looked up in the environment the code runs in, which
+	// for a function is the one it was defined in.
+	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin := s.builtins.Lookup(node.Value); builtin != nil {
 		return builtin
 	}
 	return newError("identifier not found: %s", node.Value)
 }
 
+// isGlobal reports whether name is bound in the global environment or
+// names a builtin.
+func (e *Evaluator) isGlobal(name string) bool {
+	if _, ok := e.globals.Get(name); ok {
+		return true
+	}
+	return e.builtins.Lookup(name) != nil
+}
+
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = object.NULL
+	TRUE     = object.TRUE
+	FALSE    = object.FALSE
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 func nativeBoolToBooleanObject(b bool) *object.Boolean {
@@ -317,27 +691,21 @@ func nativeBoolToBooleanObject(b bool) *object.Boolean {
 	}
 }
 
-func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
-		return false
-	default:
-		return true
-	}
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
 }
 
-func isError(obj object.Object) bool {
-	return obj.Type() == object.ERROR_OBJ
+// isAbrupt reports whether obj stops the evaluation of the expression
+// containing it: an error, or a break or continue coming out of an if
+// expression inside it.
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Error, *object.Break, *object.Continue:
+		return true
+	}
+	return false
 }
 
 func newError(format string, a ...any) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
-
-func both(left, right object.Object, objType object.ObjectType) bool {
-	return left.Type() == objType && right.Type() == objType
-}
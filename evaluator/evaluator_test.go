@@ -1,11 +1,21 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+	"unique"
 
+	"github.com/pirosiki197/monkey/compiler"
 	"github.com/pirosiki197/monkey/lexer"
 	"github.com/pirosiki197/monkey/object"
 	"github.com/pirosiki197/monkey/parser"
+	"github.com/pirosiki197/monkey/vm"
 )
 
 func TestReturnStatements(t *testing.T) {
@@ -29,7 +39,7 @@ if (10 > 1) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testIntegerObject(t, evaluated, tt.expected)
 		})
 	}
@@ -51,7 +61,7 @@ func TestIfElseExpression(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			integer, ok := tt.expected.(int)
 			if ok {
 				testIntegerObject(t, evaluated, int64(integer))
@@ -65,7 +75,7 @@ func TestIfElseExpression(t *testing.T) {
 func TestFunctionObject(t *testing.T) {
 	input := "fn(x) { x * 2; };"
 
-	evaluated := testEval(input)
+	evaluated := testEval(t, input)
 	fn, ok := evaluated.(*object.Function)
 	if !ok {
 		t.Fatalf("object is not %T. got=%T (%+v)", fn, evaluated, evaluated)
@@ -113,11 +123,114 @@ factorial(5);`,
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			testIntegerObject(t, testEval(tt.input), tt.expected)
+			testIntegerObject(t, testEval(t, tt.input), tt.expected)
 		})
 	}
 }
 
+func TestFunctionDeclarations(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected any
+	}{
+		{"declaration", "fn double(x) { x * 2 } double(4)", 8},
+		{"called_before_declaration", "let a = double(4); fn double(x) { x * 2 } a", 8},
+		{
+			"mutual_recursion_in_block",
+			`
+let parity = fn(n) {
+    if (isEven(n)) { return "even"; }
+    fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+    fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+    "odd"
+};
+parity(7)`,
+			"odd",
+		},
+		{"block_scope", "if (true) { fn f() { 1 } } f()", "identifier not found: f"},
+		{"declaration_value", "fn f() { 1 }", nil},
+		{"redefinition", "fn f() { 1 } let g = f; fn f() { 2 } g()", 2},
+		{"arity_error", "fn f(x) { x } f(1, 2)", "wrong length of arguments to f: 1 parameters but called with 2 arguments"},
+		{"let_arity_error", "let f = fn(x) { x }; f()", "wrong length of arguments to f: 1 parameters but called with 0 arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				switch obj := evaluated.(type) {
+				case *object.Error:
+					if obj.Message != expected {
+						t.Errorf("wrong error message. expected=%q, got=%q", expected, obj.Message)
+					}
+				case *object.String:
+					if obj.Value.Value() != expected {
+						t.Errorf("wrong value. expected=%q, got=%q", expected, obj.Value.Value())
+					}
+				default:
+					t.Errorf("unexpected result %T (%+v)", evaluated, evaluated)
+				}
+			case nil:
+				if evaluated != nil {
+					t.Errorf("unexpected result %s", evaluated.Inspect())
+				}
+			}
+		})
+	}
+}
+
+func TestNullResults(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() {}()", "null"},
+		{"[fn() {}()]", "[null]"},
+		{"fn() { let a = 1; }()", "null"},
+		{"let f = fn() { while (false) {} }; [f()]", "[null]"},
+		{"[if (true) { let a = 1; }]", "[null]"},
+		{"let f = fn() {}; let x = f(); x + 1", "ERROR: 1:31: type mismatch: NULL + INTEGER"},
+	}
+
+	// testEval compares the result with the closure and bytecode backends
+	for _, tt := range tests {
+		if got := inspect(testEval(t, tt.input)); got != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	builtins := object.NewRegistry()
+	builtins.Register("nothing", object.NoArguments, "nothing() returns nil.", func(...object.Object) object.Object { return nil })
+	for _, backend := range backends {
+		e := New(append(backend.opts, WithBuiltins(builtins))...)
+		if got := inspect(e.Eval(parser.New(lexer.New("[nothing()]")).ParseProgram())); got != "[null]" {
+			t.Errorf("%s: wrong result of a builtin returning nil. got=%q", backend.name, got)
+		}
+	}
+}
+
+func TestFunctionInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn(x) { x }", "fn(x){\nx\n}"},
+		{"let f = fn(x) { x }; f", "fn f(x){\nx\n}"},
+		{"fn g(x) { x } g", "fn g(x){\nx\n}"},
+		{"let f = fn(x) { x }; let h = f; h", "fn f(x){\nx\n}"},
+	}
+
+	for _, tt := range tests {
+		if got := testEval(t, tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %q. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -141,7 +254,7 @@ func TestEvalIntegerExpression(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testIntegerObject(t, evaluated, tt.expected)
 		})
 	}
@@ -179,12 +292,48 @@ func TestEvalBooleanExpression(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testBooleanObject(t, evaluated, tt.expected)
 		})
 	}
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"true || false", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 && 2", 2},
+		{"0 || 2", 0},
+		{"if (false) { 1 } || 3", 3},
+		{"if (false) { 1 } && 3", nil},
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"let n = 0; let inc = fn() { n = n + 1; true }; false && inc(); true || inc(); n", 0},
+		{"let n = 0; let inc = fn() { n = n + 1; true }; true && inc(); false || inc(); n", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			switch expected := tt.expected.(type) {
+			case bool:
+				testBooleanObject(t, evaluated, expected)
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			default:
+				testNullObject(t, evaluated)
+			}
+		})
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -200,7 +349,7 @@ func TestBangOperator(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			evaluated := testEval(tt.input)
+			evaluated := testEval(t, tt.input)
 			testBooleanObject(t, evaluated, tt.expected)
 		})
 	}
@@ -218,7 +367,7 @@ func TestLetStatements(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
 	}
 }
 
@@ -247,7 +396,167 @@ a;`,
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), tt.expected)
+		testIntegerObject(t, testEval(t, tt.input), tt.expected)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected int64
+	}{
+		{"adder", "let newAdder = fn(x) { fn(y) { x + y } }; let addTwo = newAdder(2); addTwo(3)", 5},
+		{
+			"counter",
+			`
+let newCounter = fn() {
+    let n = 0;
+    fn() { n = n + 1; n }
+};
+let c = newCounter();
+c(); c();
+c()`,
+			3,
+		},
+		{
+			"shared_capture",
+			`
+let pair = fn() {
+    let n = 0;
+    [fn() { n = n + 10 }, fn() { n }]
+};
+let p = pair();
+p[0]();
+p[1]()`,
+			10,
+		},
+		{
+			"captured_parameter",
+			`
+let f = fn(x) {
+    let inc = fn() { x = x + 1 };
+    inc();
+    x
+};
+f(1)`,
+			2,
+		},
+		{
+			"nested_capture",
+			`
+let f = fn(a) { fn(b) { fn(c) { a + b + c } } };
+f(1)(2)(3)`,
+			6,
+		},
+		{
+			"local_mutual_recursion",
+			`
+let f = fn(n) {
+    let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+    let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+    if (isEven(n)) { 1 } else { 0 }
+};
+f(10)`,
+			1,
+		},
+		{"shadowing", "let x = 1; let f = fn() { let x = x + 1; x }; f() + x", 3},
+		{"block_scope", "let x = 1; if (true) { let x = 2; } x", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testIntegerObject(t, testEval(t, tt.input), tt.expected)
+		})
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected int64
+	}{
+		{"while", "let i = 0; while (i < 10) { i = i + 1; } i", 10},
+		{"while_false", "let i = 0; while (false) { i = i + 1; } i", 0},
+		{"while_break", "let i = 0; while (true) { if (i == 5) { break; } i = i + 1; } i", 5},
+		{
+			"while_continue",
+			`
+let i = 0;
+let sum = 0;
+while (i < 10) {
+    i = i + 1;
+    if (i > 3) { continue; }
+    sum = sum + i;
+}
+sum`,
+			6,
+		},
+		{"for_in", "let sum = 0; for (x in [1, 2, 3]) { sum = sum + x; } sum", 6},
+		{"for_in_empty", "let sum = 0; for (x in []) { sum = sum + 1; } sum", 0},
+		{"for_in_break", "let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { break } sum = sum + x; } sum", 1},
+		{"for_in_continue", "let sum = 0; for (x in [1, 2, 3]) { if (x == 2) { continue } sum = sum + x; } sum", 4},
+		{
+			"nested_break",
+			`
+let n = 0;
+for (x in [1, 2, 3]) {
+    let j = 0;
+    while (true) {
+        j = j + 1;
+        if (j > x) { break; }
+        n = n + 1;
+    }
+}
+n`,
+			6,
+		},
+		{
+			"return_from_loop",
+			`
+let find = fn(xs, want) {
+    for (x in xs) {
+        if (x == want) { return x * 10; }
+    }
+    return -1;
+};
+find([1, 2, 3], 2)`,
+			20,
+		},
+		{
+			"let_per_iteration",
+			`
+let f = fn() { 0 };
+let g = f;
+for (x in [1, 2]) {
+    let y = x;
+    if (x == 1) { f = fn() { y }; } else { g = fn() { y }; }
+}
+f() + g()`,
+			3,
+		},
+		{"long_loop", "let i = 0; while (i < 100000) { i = i + 1; } i", 100000},
+		{"break_in_let", "let i = 0; while (true) { i = i + 1; let y = if (i > 3) { break } else { 1 }; } i", 4},
+		{"break_in_argument", "let n = 0; while (true) { n = n + 1; puts(if (true) { break }) } n", 1},
+		{"break_in_infix", "let i = 0; while (true) { i = i + 1 + if (i > 2) { break } else { 0 }; } i", 3},
+		{"continue_in_hash", "let n = 0; for (x in [1, 2, 3]) { let h = {x: if (x == 2) { continue } else { x }}; n = n + h[x]; } n", 4},
+		{
+			"continue_in_array",
+			"let i = 0; while (i < 5000) { i = i + 1; let a = [1, 2, if (true) { continue }]; } i",
+			5000,
+		},
+		{
+			"continue_in_index",
+			"let n = 0; for (x in [1, 2, 3]) { n = n + [10, 20][if (x == 2) { continue } else { 0 }]; } n",
+			20,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testIntegerObject(t, testEval(t, tt.input), tt.expected)
+		})
 	}
 }
 
@@ -298,10 +607,62 @@ if (10 > 1) {
 			"foo = 4;",
 			"identifier not found: foo",
 		},
+		{
+			"[1, 2, 3][3]",
+			"index out of range: 3 with length 3",
+		},
+		{
+			"[1, 2, 3][-1]",
+			"index out of range: -1 with length 3",
+		},
+		{
+			`[1, 2, 3]["a"]`,
+			"index operator not supported: ARRAY[STRING]",
+		},
+		{
+			"1[0]",
+			"index operator not supported: INTEGER[INTEGER]",
+		},
+		{
+			"len(1, 2)",
+			"wrong number of arguments. expected 1 but got 2",
+		},
+		{
+			`{"name": "Monkey"}[fn(x) { x }];`,
+			"unusable as hash key: FUNCTION",
+		},
+		{
+			`{[1]: 2}`,
+			"unusable as hash key: ARRAY",
+		},
+		{
+			"for (x in 5) { x }",
+			"cannot iterate over INTEGER",
+		},
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"let f = fn(x) { 10 / x }; f(0)",
+			"division by zero",
+		},
+		{
+			"let min = -9223372036854775807 - 1; -min",
+			"integer overflow: -(-9223372036854775808)",
+		},
+		{
+			"let f = fn() { f() }; f()",
+			"maximum call depth of 10000 exceeded",
+		},
+		{
+			"let i = 0; while (true) { i = i + 1; if (i == 3) { i + true } }",
+			"type mismatch: INTEGER + BOOLEAN",
+		},
 	}
 
 	for _, tt := range tests {
-		evaluated := testEval(tt.input)
+		evaluated := testEval(t, tt.input)
 
 		errObj, ok := evaluated.(*object.Error)
 		if !ok {
@@ -315,6 +676,448 @@ if (10 > 1) {
 	}
 }
 
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "ERROR: 1:1: type mismatch: INTEGER + BOOLEAN"},
+		{"let a = 1;\nlet b = a + c;", "ERROR: 2:13: identifier not found: c"},
+		{"let f = fn(x) {\n  x + true\n};\nf(1);", "ERROR: 2:3: type mismatch: INTEGER + BOOLEAN\n    at f/1 (4:1)"},
+		{"if (true) {\n  len(1)\n}", "ERROR: 2:3: argument to `len` not supported, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(t, tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Inspect())
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	input := `
+let inner = fn(x) { x / 0 };
+let outer = fn(a, b) { inner(a) + b };
+let apply = fn(f) { f(1, 2) };
+apply(outer)`
+
+	evaluated := testEval(t, input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := []struct {
+		function string
+		line     int
+		column   int
+		numArgs  int
+	}{
+		{"inner", 3, 24, 1},
+		{"outer", 4, 21, 2},
+		{"apply", 5, 1, 1},
+	}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong stack depth. want=%d, got=%v", len(expected), errObj.Stack)
+	}
+	for i, want := range expected {
+		frame := errObj.Stack[i]
+		if frame.Function != want.function || frame.Pos.Line != want.line ||
+			frame.Pos.Column != want.column || frame.NumArgs != want.numArgs {
+			t.Errorf("Stack[%d] wrong. want=%+v, got=%+v", i, want, frame)
+		}
+	}
+
+	expectedInspect := `ERROR: 2:21: division by zero
+    at inner/1 (3:24)
+    at outer/2 (4:21)
+    at apply/1 (5:1)`
+	if errObj.Inspect() != expectedInspect {
+		t.Errorf("wrong Inspect.\nwant=%s\ngot= %s", expectedInspect, errObj.Inspect())
+	}
+}
+
+func TestStackTraceElision(t *testing.T) {
+	evaluated := testEval(t, "let f = fn(n) { if (n == 0) { n + true } else { f(n - 1) } }; fn() { f(30) }()")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+	}
+	if len(errObj.Stack) != 32 {
+		t.Fatalf("wrong stack depth. want=32, got=%d", len(errObj.Stack))
+	}
+
+	lines := strings.Split(errObj.Inspect(), "\n")
+	expected := []string{
+		"    at f/1 (1:49)",
+		"    ... 12 more frames",
+		"    at f/1 (1:49)",
+		"    at f/1 (1:70)",
+		"    at <anonymous>/0 (1:63)",
+	}
+	if len(lines) != 22 {
+		t.Fatalf("wrong number of lines. want=22, got=%d:\n%s", len(lines), errObj.Inspect())
+	}
+	got := []string{lines[10], lines[11], lines[12], lines[20], lines[21]}
+	if !slices.Equal(got, expected) {
+		t.Errorf("wrong trace.\nwant=%q\ngot= %q", expected, got)
+	}
+}
+
+func TestUnresolvedIdentifiers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (false) { missing }", "ERROR: 1:14: identifier not found: missing"},
+		{"let f = fn() { g() }; f()", "ERROR: 1:16: identifier not found: g\n    at f/0 (1:23)"},
+		{"let f = fn() { x = 1 }; f()", "ERROR: 1:16: identifier not found: x\n    at f/0 (1:25)"},
+		{"x + fn() { z }() + y", "ERROR: 1:1: identifier not found: x (and 1 more errors)"},
+		{"if (true) { let x = 1; } x", "ERROR: 1:26: identifier not found: x"},
+		{"for (x in []) { } x", "ERROR: 1:19: identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			evaluated := New().Eval(program)
+
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+			}
+			if errObj.Inspect() != tt.expected {
+				t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Inspect())
+			}
+		})
+	}
+}
+
+func TestUnboundLocals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn(x) { x }; f(fn() {}())", "null"},
+		{"if (true) { let g = fn() { y }; let a = g(); let y = 1; a }", "ERROR: 1:28: identifier not found: y\n    at g/0 (1:41)"},
+		{"if (true) { let g = fn() { y }; let y = fn() {}(); g() }", "null"},
+	}
+
+	for _, tt := range tests {
+		if got := inspect(testEval(t, tt.input)); got != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// a slot is bound once assigned, whatever the value
+	env := object.NewEnclosedEnvironment(object.NewEnvironment(), 1)
+	if _, ok := env.GetAt(0, 0); ok {
+		t.Errorf("slot bound before assignment")
+	}
+	env.SetAt(0, 0, nil)
+	if _, ok := env.GetAt(0, 0); !ok {
+		t.Errorf("slot holding nil not bound")
+	}
+}
+
+func TestGlobalsAcrossPrograms(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			env := object.NewEnvironment()
+			env.Set("host", &object.Integer{Value: 40})
+			e := NewWithEnv(env, backend.opts...)
+
+			for _, input := range []string{"let a = host + 1;", "let f = fn() { a + 1 };"} {
+				if result := e.Eval(parser.New(lexer.New(input)).ParseProgram()); isError(result) {
+					t.Fatalf("Eval(%q) = %s", input, result.Inspect())
+				}
+			}
+			testIntegerObject(t, e.Eval(parser.New(lexer.New("f()")).ParseProgram()), 42)
+		})
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	input := `
+let count = fn(n) {
+    if (n == 0) { return 0; }
+    1 + count(n - 1)
+};
+count(%d)`
+
+	tests := []struct {
+		depth    int
+		n        int
+		expected any
+	}{
+		{10, 9, 9},
+		{10, 10, "maximum call depth of 10 exceeded"},
+		{0, 20000, 20000},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			p := parser.New(lexer.New(fmt.Sprintf(input, tt.n)))
+			program := p.ParseProgram()
+			e := New(append(backend.opts, WithMaxCallDepth(tt.depth))...)
+
+			evaluated := e.Eval(program)
+			switch expected := tt.expected.(type) {
+			case int:
+				testIntegerObject(t, evaluated, int64(expected))
+			case string:
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Errorf("%s: no error object returned. got=%T (%+v)", backend.name, evaluated, evaluated)
+					continue
+				}
+				if errObj.Message != expected {
+					t.Errorf("%s: wrong error message. expected=%q, got=%q", backend.name, expected, errObj.Message)
+				}
+
+				// the evaluator must stay usable after the error
+				testIntegerObject(t, e.Eval(parser.New(lexer.New("count(3)")).ParseProgram()), 3)
+			}
+		}
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	zeros := object.DefaultRegistry()
+	zeros.Register("zeros", 1, "zeros(n) returns an array of n zeros.", func(args ...object.Object) object.Object {
+		elements := make([]object.Object, args[0].(*object.Integer).Value)
+		for i := range elements {
+			elements[i] = &object.Integer{Value: 0}
+		}
+		return &object.Array{Elements: elements}
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		opts     []Option
+		expected error
+	}{
+		{"step_limit_loop", "while (true) { }", []Option{WithMaxSteps(1000)}, ErrStepLimit},
+		{"step_limit_recursion", "let f = fn() { f() }; f()", []Option{WithMaxSteps(1000), WithMaxCallDepth(0)}, ErrStepLimit},
+		{"size_limit_string", `let s = "x"; while (true) { s = s + s }`, []Option{WithMaxSize(1 << 20)}, ErrSizeLimit},
+		{"size_limit_array", "zeros(1001)", []Option{WithMaxSize(1000), WithBuiltins(zeros)}, ErrSizeLimit},
+		{"step_limit_function_statement", "fn f() { }", []Option{WithMaxSteps(2)}, ErrStepLimit},
+		{"cancelled", "1 + 1", []Option{WithContext(cancelled)}, context.Canceled},
+		{"timeout", "while (true) { }", []Option{WithContext(timeout)}, context.DeadlineExceeded},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			t.Run(backend.name+"/"+tt.name, func(t *testing.T) {
+				program := parser.New(lexer.New(tt.input)).ParseProgram()
+				evaluated := New(append(backend.opts, tt.opts...)...).Eval(program)
+
+				errObj, ok := evaluated.(*object.Error)
+				if !ok {
+					t.Fatalf("no error object returned. got=%T (%+v)", evaluated, evaluated)
+				}
+				if !errors.Is(errObj, tt.expected) {
+					t.Errorf("errors.Is(%q, %q) is false", errObj, tt.expected)
+				}
+				cancelled := tt.expected != ErrStepLimit && tt.expected != ErrSizeLimit
+				if cancelled && !errors.Is(errObj, ErrCancelled) {
+					t.Errorf("errors.Is(%q, ErrCancelled) is false", errObj)
+				}
+			})
+		}
+	}
+}
+
+func TestStepLimitIsPerEval(t *testing.T) {
+	for _, backend := range backends {
+		e := New(append(backend.opts, WithMaxSteps(50))...)
+		program := parser.New(lexer.New("let i = 0; while (i < 3) { i = i + 1; } i")).ParseProgram()
+
+		for range 3 {
+			testIntegerObject(t, e.Eval(program), 3)
+		}
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	builtins := object.DefaultRegistry()
+	builtins.Register("boom", object.NoArguments, "boom() panics.", func(args ...object.Object) object.Object {
+		panic("boom")
+	})
+
+	for _, backend := range backends {
+		e := New(append(backend.opts, WithBuiltins(builtins))...)
+		evaluated := e.Eval(parser.New(lexer.New("1 + boom()")).ParseProgram())
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("%s: no error object returned. got=%T (%+v)", backend.name, evaluated, evaluated)
+		}
+		if errObj.Message != "internal error: boom" {
+			t.Errorf("%s: wrong error message. got=%q", backend.name, errObj.Message)
+		}
+
+		testIntegerObject(t, e.Eval(parser.New(lexer.New("1 + 2")).ParseProgram()), 3)
+	}
+}
+
+func TestBuiltinRegistry(t *testing.T) {
+	builtins := object.NewRegistry()
+	builtins.Register("double", 1, "double(x) returns 2 * x.", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"double(21)", "42"},
+		{"double(1, 2)", "ERROR: 1:1: wrong number of arguments. expected 1 but got 2"},
+		{"len([])", "ERROR: 1:1: identifier not found: len"},
+		{"let double = fn(x) { x }; double(1)", "1"},
+	}
+
+	for _, backend := range backends {
+		for _, tt := range tests {
+			e := New(append(backend.opts, WithBuiltins(builtins))...)
+			if got := inspect(e.Eval(parser.New(lexer.New(tt.input)).ParseProgram())); got != tt.expected {
+				t.Errorf("%s: wrong result for %q. expected=%q, got=%q", backend.name, tt.input, tt.expected, got)
+			}
+		}
+	}
+
+	// evaluators without a registry keep the default builtins
+	testIntegerObject(t, testEval(t, "len([1])"), 1)
+}
+
+func TestCall(t *testing.T) {
+	input := `
+let count = 0;
+on(fn(n) { count = count + n; return count; });
+on(fn() { while (true) { } });
+fn fail(x) { x / 0 }
+on(fail);
+apply(fn(x) { x * 2 }, 21)`
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			var e *Evaluator
+			var handlers []object.Object
+			builtins := object.DefaultRegistry()
+			builtins.Register("on", 1, "on(f) registers the handler f.", func(args ...object.Object) object.Object {
+				handlers = append(handlers, args[0])
+				return nil
+			})
+			builtins.Register("apply", 2, "apply(f, x) calls f with x.", func(args ...object.Object) object.Object {
+				result, err := e.Call(context.Background(), args[0], args[1])
+				if err != nil {
+					return err.(*object.Error)
+				}
+				return result
+			})
+			e = New(append(backend.opts, WithBuiltins(builtins), WithMaxSteps(1000))...)
+
+			testIntegerObject(t, e.Eval(parser.New(lexer.New(input)).ParseProgram()), 42)
+			if len(handlers) != 3 {
+				t.Fatalf("wrong number of handlers. got=%d", len(handlers))
+			}
+			add, loop, fail := handlers[0], handlers[1], handlers[2]
+
+			ctx := context.Background()
+			for i, expected := range []int64{1, 3} {
+				result, err := e.Call(ctx, add, &object.Integer{Value: int64(i + 1)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				testIntegerObject(t, result, expected)
+			}
+			if result, err := e.Call(ctx, builtins.Lookup("len"), &object.Array{}); err != nil || result.Inspect() != "0" {
+				t.Errorf("calling a builtin: %v, %v", result, err)
+			}
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			tests := []struct {
+				ctx      context.Context
+				fn       object.Object
+				args     []object.Object
+				expected string
+				is       error
+			}{
+				{ctx, add, nil, "wrong length of arguments: 1 parameters but called with 0 arguments", nil},
+				{ctx, &object.Integer{Value: 1}, nil, "not a function: INTEGER", nil},
+				{ctx, fail, []object.Object{&object.Integer{Value: 1}}, "5:14: division by zero\n    at fail/1", nil},
+				{ctx, loop, nil, "4:24: step limit exceeded\n    at <anonymous>/0", ErrStepLimit},
+				{cancelled, add, []object.Object{&object.Integer{Value: 1}}, "execution cancelled: context canceled", ErrCancelled},
+			}
+			for _, tt := range tests {
+				result, err := e.Call(tt.ctx, tt.fn, tt.args...)
+				errObj, ok := err.(*object.Error)
+				if !ok || result != nil {
+					t.Errorf("Call(%s) = %v, %v. want an error object", tt.fn.Inspect(), result, err)
+					continue
+				}
+				if errObj.Trace() != tt.expected {
+					t.Errorf("wrong error. expected=%q, got=%q", tt.expected, errObj.Trace())
+				}
+				if tt.is != nil && !errors.Is(err, tt.is) {
+					t.Errorf("errors.Is(%q, %q) is false", err, tt.is)
+				}
+			}
+
+			// the evaluator must stay usable after the errors
+			result, err := e.Call(ctx, add, &object.Integer{Value: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testIntegerObject(t, result, 4)
+		})
+	}
+}
+
+func TestGlobalsOfFunctions(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			// a function reads and assigns the globals it was defined with,
+			// whichever evaluator calls it
+			a := New(backend.opts...)
+			f := a.Eval(parser.New(lexer.New("let x = 1; fn(n) { x = x + n; x }")).ParseProgram())
+			b := New(backend.opts...)
+			b.Eval(parser.New(lexer.New("let x = 100;")).ParseProgram())
+			result, err := b.Call(context.Background(), f, &object.Integer{Value: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testIntegerObject(t, result, 2)
+			testIntegerObject(t, a.Eval(parser.New(lexer.New("x")).ParseProgram()), 2)
+			testIntegerObject(t, b.Eval(parser.New(lexer.New("x")).ParseProgram()), 100)
+
+			// a function may use a global the next program defines, as on
+			// successive lines of the REPL
+			e := New(backend.opts...)
+			for _, input := range []string{"let f = fn() { g() };", "let g = fn() { 1 };"} {
+				if result := e.Eval(parser.New(lexer.New(input)).ParseProgram()); result != nil {
+					t.Fatalf("Eval(%q) = %s", input, result.Inspect())
+				}
+			}
+			testIntegerObject(t, e.Eval(parser.New(lexer.New("f()")).ParseProgram()), 1)
+		})
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -323,20 +1126,276 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo, 世界")`, 14},
+		{`len("a\tb\u{1F600}")`, 7},
+		{`len([])`, 0},
+		{`len([1, 2, 3])`, 3},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(t, tt.input), int64(tt.expected))
+	}
+}
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(t, input)
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not %T. got=%T (%+v)", result, evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d", len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+
+	if result.Inspect() != "[1, 4, 6]" {
+		t.Errorf("result.Inspect() wrong. got=%q", result.Inspect())
+	}
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[[1, 2], [3, 4]][1][0]", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			testIntegerObject(t, testEval(t, tt.input), tt.expected)
+		})
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+{
+    "one": 10 - 9,
+    two: 1 + 1,
+    "thr" + "ee": 6 / 2,
+    4: 4,
+    true: 5,
+    false: 6
+}`
+
+	evaluated := testEval(t, input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return %T. got=%T (%+v)", result, evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: unique.Make("one")}).HashKey():   1,
+		(&object.String{Value: unique.Make("two")}).HashKey():   2,
+		(&object.String{Value: unique.Make("three")}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():                   4,
+		TRUE.HashKey():                                          5,
+		FALSE.HashKey():                                         6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+			continue
+		}
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+
+	if result.Inspect() != "{4: 4, false: 6, one: 1, three: 3, true: 5, two: 2}" {
+		t.Errorf("result.Inspect() wrong. got=%q", result.Inspect())
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+		{`{1: 5}[true]`, nil},
+		{`len({1: 2, 3: 4})`, 2},
 	}
 
 	for _, tt := range tests {
-		testIntegerObject(t, testEval(tt.input), int64(tt.expected))
+		t.Run(tt.input, func(t *testing.T) {
+			evaluated := testEval(t, tt.input)
+			integer, ok := tt.expected.(int)
+			if ok {
+				testIntegerObject(t, evaluated, int64(integer))
+			} else {
+				testNullObject(t, evaluated)
+			}
+		})
 	}
 }
 
-func testEval(input string) object.Object {
+// backends lists the options selecting each evaluation strategy.
+var backends = []struct {
+	name string
+	opts []Option
+}{
+	{"walker", nil},
+	{"closures", []Option{WithClosureCompilation()}},
+}
+
+// FuzzEval checks that evaluating a program never fails internally and
+// that both backends agree on its result.
+func FuzzEval(f *testing.F) {
+	for _, seed := range []string{
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)",
+		`let h = {"a": [1, 2], true: "x"}; h["a"][1] + len(h[true])`,
+		"let i = 0; while (true) { i = i + 1; if (i > 3) { break } } i",
+		"let s = 0; for (x in [1, 2, 3]) { if (x == 2) { continue } s = s + x; } s",
+		"fn even(n) { if (n == 0) { true } else { odd(n - 1) } } fn odd(n) { !even(n) } even(4)",
+		"let f = fn() { f() }; f()",
+		"-9223372036854775807 - 2 / 0",
+	} {
+		f.Add(seed)
+	}
+
+	builtins := object.DefaultRegistry()
+	builtins.Add(object.Puts(io.Discard))
+
+	f.Fuzz(func(t *testing.T, input string) {
+		if len(input) > 1000 {
+			// long inputs reach no new code and make minimizing slow
+			return
+		}
+		p := parser.New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			return
+		}
+
+		var results [2]string
+		for i, backend := range backends {
+			e := New(append(backend.opts, WithBuiltins(builtins), WithMaxSteps(10000), WithMaxCallDepth(100), WithMaxSize(1<<16))...)
+			result := e.Eval(program)
+			if err, ok := result.(*object.Error); ok && strings.HasPrefix(err.Message, "internal error") {
+				t.Fatalf("%s: %q: %s", backend.name, input, err.Trace())
+			}
+			results[i] = inspect(result)
+		}
+		if results[0] != results[1] {
+			t.Errorf("%q: results differ. walker=%s, closures=%s", input, results[0], results[1])
+		}
+	})
+}
+
+func BenchmarkFib(b *testing.B) {
+	benchmarkBackends(b, `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20)`)
+}
+
+func BenchmarkStringBuilding(b *testing.B) {
+	benchmarkBackends(b, `
+let s = "";
+let i = 0;
+while (i < 2000) {
+    s = s + "x";
+    i = i + 1;
+}
+len(s)`)
+}
+
+func benchmarkBackends(b *testing.B, input string) {
+	for _, backend := range backends {
+		b.Run(backend.name, func(b *testing.B) {
+			program := parser.New(lexer.New(input)).ParseProgram()
+			for range b.N {
+				if result := New(backend.opts...).Eval(program); isError(result) {
+					b.Fatal(result.Inspect())
+				}
+			}
+		})
+	}
+}
+
+// testEval evaluates input with the evaluator and checks that the
+// compiler and virtual machine produce the same result.
+func testEval(t *testing.T, input string) object.Object {
+	t.Helper()
+
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	e := New()
+	evaluated := e.Eval(program)
+
+	c := New(WithClosureCompilation())
+	closures := c.Eval(program)
+	if inspect(closures) != inspect(evaluated) {
+		t.Errorf("closure result differs for %q. walker=%s, closures=%s", input, inspect(evaluated), inspect(closures))
+	}
+	if c.steps != e.steps {
+		t.Errorf("closures took %d steps for %q, walker took %d", c.steps, input, e.steps)
+	}
+
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	compiled := vm.New(comp.Bytecode()).Run()
+	if !sameResult(evaluated, compiled) {
+		t.Errorf("vm result differs for %q. evaluator=%s, vm=%s", input, inspect(evaluated), inspect(compiled))
+	}
+
+	return evaluated
+}
+
+// sameResult reports whether the results of the evaluator and the
+// virtual machine agree. A missing value matches null, functions are
+// only compared by type since their representations differ, and errors
+// are compared without their stack, which the virtual machine does not
+// record.
+func sameResult(evaluated, compiled object.Object) bool {
+	if evaluated == nil {
+		evaluated = NULL
+	}
+	if compiled == nil {
+		compiled = NULL
+	}
+	if evaluated.Type() != compiled.Type() {
+		return false
+	}
+	if err, ok := evaluated.(*object.Error); ok {
+		return err.Error() == compiled.(*object.Error).Error()
+	}
+	return evaluated.Type() == object.FUNCTION_OBJ || evaluated.Inspect() == compiled.Inspect()
+}
 
-	return e.Eval(program)
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "<nil>"
+	}
+	return obj.Inspect()
 }
 
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
@@ -2,38 +2,131 @@ package lexer
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/pirosiki197/monkey/diag"
 	"github.com/pirosiki197/monkey/token"
 )
 
+// Mode controls optional lexer behavior.
+type Mode uint
+
+const (
+	// ScanComments makes NextToken return comments as COMMENT tokens
+	// instead of skipping them.
+	ScanComments Mode = 1 << iota
+)
+
+// Lexer splits Monkey source into tokens.
+//
+// Two forms of comments are recognized: line comments start with //
+// and run until the end of the line, and block comments start with /*
+// and end at the first following */. Block comments do not nest, so
+// "/* a /* b */" is a single complete comment. The literal of a
+// COMMENT token is the full comment text including the delimiters.
+//
+// A line starting with #! at the very beginning of the input is ignored
+// so that scripts can be made executable.
+//
+// The input is read as UTF-8, so identifiers may contain any Unicode
+// letter. Double-quoted strings support the escapes \n, \t, \r, \\, \"
+// and \u{X} (1 to 6 hex digits); backtick-quoted raw strings may span
+// multiple lines and contain no escapes. The literal of a STRING token
+// is the decoded string value.
+//
+// Malformed input gives ILLEGAL tokens, whose literal is the offending
+// character or a description of the problem. Each ILLEGAL token is also
+// reported as a diagnostic, see Diagnostics.
 type Lexer struct {
+	mode Mode
+
+	filename     string
 	input        string
-	position     int
-	readPosition int
-	ch           byte
+	position     int  // offset of ch
+	readPosition int  // offset after ch
+	ch           rune // current character, 0 at EOF
+
+	line      int // line of ch
+	lineStart int // offset of the first character of line
+
+	diags []*diag.Diagnostic
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename returns a Lexer whose token positions refer to filename.
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
+	if strings.HasPrefix(input, "#!") {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	}
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
+}
+
+// SetMode changes the lexer mode for subsequent calls to NextToken.
+func (l *Lexer) SetMode(mode Mode) {
+	l.mode = mode
+}
+
+// Diagnostics returns the problems found in the input read so far, one
+// for each ILLEGAL token.
+func (l *Lexer) Diagnostics() []*diag.Diagnostic {
+	return l.diags
+}
+
+// report records a diagnostic for the ILLEGAL token tok.
+func (l *Lexer) report(tok token.Token, code diag.Code, msg string, fix *diag.Fix) {
+	l.diags = append(l.diags, &diag.Diagnostic{
+		Severity: diag.Error,
+		Code:     code,
+		Message:  msg,
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Fix:      fix,
+	})
+}
+
+// pos returns the position of the current character.
+func (l *Lexer) pos() token.Position {
+	offset := min(l.position, len(l.input))
+	return token.Position{
+		Filename: l.filename,
+		Offset:   offset,
+		Line:     l.line,
+		Column:   offset - l.lineStart + 1,
+	}
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		r, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return r
 	}
 }
 
@@ -53,11 +146,81 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
+// readString reads a double-quoted string and decodes its escape sequences.
+// On a bad escape it still consumes the rest of the string so that lexing
+// can resume after the closing quote.
 func (l *Lexer) readString() (string, error) {
+	var out strings.Builder
+	var firstErr error
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			return out.String(), firstErr
+		case 0:
+			return "", errors.New("unexpected EOF")
+		case '\\':
+			l.readChar()
+			if err := l.readEscape(&out); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		default:
+			// copy the raw bytes so invalid UTF-8 is preserved as is
+			out.WriteString(l.input[l.position:l.readPosition])
+		}
+	}
+}
+
+// readEscape decodes the escape sequence whose first character after the
+// backslash is the current character.
+func (l *Lexer) readEscape(out *strings.Builder) error {
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '\\':
+		out.WriteByte('\\')
+	case '"':
+		out.WriteByte('"')
+	case 'u':
+		if l.peekChar() != '{' {
+			return errors.New("invalid unicode escape: expected {")
+		}
+		l.readChar()
+		position := l.readPosition
+		for l.peekChar() != '}' {
+			if l.peekChar() == '"' || l.peekChar() == 0 {
+				return errors.New("invalid unicode escape: missing }")
+			}
+			l.readChar()
+		}
+		digits := l.input[position:l.readPosition]
+		l.readChar()
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || len(digits) > 6 {
+			return fmt.Errorf("invalid unicode escape: \\u{%s}", digits)
+		}
+		if !utf8.ValidRune(rune(code)) {
+			return fmt.Errorf("invalid unicode code point: \\u{%s}", digits)
+		}
+		out.WriteRune(rune(code))
+	case 0:
+		return errors.New("unexpected EOF")
+	default:
+		return fmt.Errorf("unknown escape sequence: \\%c", l.ch)
+	}
+	return nil
+}
+
+// readRawString reads a backtick-quoted string verbatim.
+func (l *Lexer) readRawString() (string, error) {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' {
+		if l.ch == '`' {
 			break
 		}
 		if l.ch == 0 {
@@ -67,6 +230,34 @@ func (l *Lexer) readString() (string, error) {
 	return l.input[position:l.position], nil
 }
 
+func (l *Lexer) atComment() bool {
+	return l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*')
+}
+
+// readComment reads a line or block comment starting at the current character.
+func (l *Lexer) readComment() (string, error) {
+	position := l.position
+	l.readChar()
+	if l.ch == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		return l.input[position:l.position], nil
+	}
+	l.readChar()
+	for {
+		if l.ch == 0 {
+			return "", errors.New("unterminated block comment")
+		}
+		if l.ch == '*' && l.peekChar() == '/' {
+			l.readChar()
+			l.readChar()
+			return l.input[position:l.position], nil
+		}
+		l.readChar()
+	}
+}
+
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -77,7 +268,27 @@ func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	for l.atComment() {
+		pos := l.pos()
+		comment, err := l.readComment()
+		if err != nil {
+			tok := token.Token{Type: token.ILLEGAL, Literal: err.Error(), Pos: pos, End: l.pos()}
+			l.report(tok, diag.UnterminatedComment, err.Error(), nil)
+			return tok
+		}
+		if l.mode&ScanComments != 0 {
+			return token.Token{Type: token.COMMENT, Literal: comment, Pos: pos, End: l.pos()}
+		}
+		l.skipWhitespace()
+	}
+	pos := l.pos()
 
+	// diagnostic of an ILLEGAL token, if it is not an illegal character,
+	// and the text suggested to replace it with
+	var (
+		code     diag.Code
+		msg, fix string
+	)
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -90,6 +301,8 @@ func (l *Lexer) NextToken() token.Token {
 		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -131,15 +344,44 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.AND, Literal: literal}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+			fix = "&&"
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok = token.Token{Type: token.OR, Literal: literal}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+			fix = "||"
+		}
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
-	case '"':
-		s, err := l.readString()
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
+	case '"', '`':
+		read := l.readString
+		if l.ch == '`' {
+			read = l.readRawString
+		}
+		s, err := read()
 		if err != nil {
 			tok.Type = token.ILLEGAL
 			tok.Literal = err.Error()
+			code, msg = diag.InvalidString, "invalid string literal: "+err.Error()
 		} else {
 			tok.Type = token.STRING
 			tok.Literal = s
@@ -147,32 +389,48 @@ func (l *Lexer) NextToken() token.Token {
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		tok.Pos, tok.End = pos, pos
+		return tok
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos, tok.End = pos, l.pos()
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Pos, tok.End = pos, l.pos()
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:l.readPosition]}
 		}
 	}
 
 	l.readChar()
+	tok.Pos, tok.End = pos, l.pos()
+	if tok.Type == token.ILLEGAL {
+		if msg == "" {
+			code, msg = diag.IllegalCharacter, fmt.Sprintf("illegal character %q", tok.Literal)
+		}
+		var suggestion *diag.Fix
+		if fix != "" {
+			suggestion = &diag.Fix{Message: fmt.Sprintf("replace with %q", fix), Pos: tok.Pos, End: tok.End, NewText: fix}
+		}
+		l.report(tok, code, msg, suggestion)
+	}
 	return tok
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
@@ -3,6 +3,7 @@ package lexer
 import (
 	"testing"
 
+	"github.com/pirosiki197/monkey/diag"
 	"github.com/pirosiki197/monkey/token"
 )
 
@@ -16,7 +17,7 @@ let add = fn(x, y) {
 
 let result = add(five, ten);
 
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -29,9 +30,13 @@ if (5 < 10) {
 10 != 9;
 10 >= 9;
 9 <= 10;
+a && b || c;
 
 "foobar"
 "foo bar"
+[1, 2];
+{"foo": "bar"}
+while for in break continue
 `
 
 	tests := []struct {
@@ -124,9 +129,31 @@ if (5 < 10) {
 		{token.LT_EQ, "<="},
 		{token.INT, "10"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
 
 		{token.STRING, "foobar"},
 		{token.STRING, "foo bar"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.STRING, "foo"},
+		{token.COLON, ":"},
+		{token.STRING, "bar"},
+		{token.RBRACE, "}"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
 
 		{token.EOF, ""},
 	}
@@ -144,3 +171,246 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"ab\" >= y"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.LET, token.Position{Filename: "a.mk", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "a.mk", Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Filename: "a.mk", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "a.mk", Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Filename: "a.mk", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "a.mk", Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Filename: "a.mk", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "a.mk", Offset: 9, Line: 1, Column: 10}},
+		{token.SEMICOLON, token.Position{Filename: "a.mk", Offset: 9, Line: 1, Column: 10}, token.Position{Filename: "a.mk", Offset: 10, Line: 1, Column: 11}},
+		{token.STRING, token.Position{Filename: "a.mk", Offset: 13, Line: 2, Column: 3}, token.Position{Filename: "a.mk", Offset: 17, Line: 2, Column: 7}},
+		{token.GT_EQ, token.Position{Filename: "a.mk", Offset: 18, Line: 2, Column: 8}, token.Position{Filename: "a.mk", Offset: 20, Line: 2, Column: 10}},
+		{token.IDENT, token.Position{Filename: "a.mk", Offset: 21, Line: 2, Column: 11}, token.Position{Filename: "a.mk", Offset: 22, Line: 2, Column: 12}},
+		{token.EOF, token.Position{Filename: "a.mk", Offset: 22, Line: 2, Column: 12}, token.Position{Filename: "a.mk", Offset: 22, Line: 2, Column: 12}},
+	}
+
+	l := NewWithFilename("a.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType.String(), tok.Type.String())
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 1; // trailing
+/* block
+   comment */ x /* a /* b */ / 2
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// leading comment"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// trailing"},
+		{token.COMMENT, "/* block\n   comment */"},
+		{token.IDENT, "x"},
+		{token.COMMENT, "/* a /* b */"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.ILLEGAL, "unterminated block comment"},
+		{token.EOF, ""},
+	}
+
+	for _, mode := range []Mode{0, ScanComments} {
+		l := New(input)
+		l.SetMode(mode)
+
+		for i, tt := range tests {
+			if tt.expectedType == token.COMMENT && mode&ScanComments == 0 {
+				continue
+			}
+			tok := l.NextToken()
+
+			if tok.Type != tt.expectedType {
+				t.Fatalf("mode %d tests[%d] - tokentype wrong. expected=%q, got=%q", mode, i, tt.expectedType.String(), tok.Type.String())
+			}
+			if tok.Literal != tt.expectedLiteral {
+				t.Fatalf("mode %d tests[%d] - literal wrong. expected=%q, got=%q", mode, i, tt.expectedLiteral, tok.Literal)
+			}
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	input := `"a\"b\\c\n\t\r"
+"\u{48}\u{e9}\u{1F600}"
+` + "`raw \\n\n\"line\"`" + `
+"héllo 世界"
+let 変数 = "x";
+"bad \q escape" 1
+"\u{110000}"
+"\u{zz}"
+` + "`unterminated"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "a\"b\\c\n\t\r"},
+		{token.STRING, "Hé😀"},
+		{token.STRING, "raw \\n\n\"line\""},
+		{token.STRING, "héllo 世界"},
+		{token.LET, "let"},
+		{token.IDENT, "変数"},
+		{token.ASSIGN, "="},
+		{token.STRING, "x"},
+		{token.SEMICOLON, ";"},
+		{token.ILLEGAL, `unknown escape sequence: \q`},
+		{token.INT, "1"},
+		{token.ILLEGAL, `invalid unicode code point: \u{110000}`},
+		{token.ILLEGAL, `invalid unicode escape: \u{zz}`},
+		{token.ILLEGAL, "unexpected EOF"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q (%q)", i, tt.expectedType.String(), tok.Type.String(), tok.Literal)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestNonASCIIPositions(t *testing.T) {
+	l := New("\"é\" x\n世 y")
+
+	expected := []token.Position{
+		{Offset: 0, Line: 1, Column: 1},
+		{Offset: 5, Line: 1, Column: 6},
+		{Offset: 7, Line: 2, Column: 1},
+		{Offset: 11, Line: 2, Column: 5},
+	}
+	for i, pos := range expected {
+		tok := l.NextToken()
+		if tok.Pos != pos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, pos, tok.Pos)
+		}
+	}
+}
+
+func TestShebang(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.TokenType
+	}{
+		{"#!/usr/bin/env monkey\nlet", []token.TokenType{token.LET, token.EOF}},
+		{"#!/usr/bin/env monkey", []token.TokenType{token.EOF}},
+		{"let\n#!", []token.TokenType{token.LET, token.ILLEGAL, token.BANG, token.EOF}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for i, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != expected {
+				t.Fatalf("%q: tokens[%d] - tokentype wrong. expected=%q, got=%q", tt.input, i, expected, tok.Type)
+			}
+		}
+	}
+}
+
+// FuzzLexer checks that the lexer reaches EOF on any input and returns
+// tokens with ordered positions within the input.
+func FuzzLexer(f *testing.F) {
+	for _, seed := range []string{
+		"let add = fn(x, y) { x + y; }; add(1, 2) >= 3 && !false",
+		`"a\n\u{1F600}" "\q" "\u{zz` + "`raw\nstring`",
+		"/* block */ // line\n#! x 世界 & | 0x1F",
+		"#!/usr/bin/env monkey\n/* unterminated",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		l := New(input)
+		l.SetMode(ScanComments)
+		end := 0
+		for range len(input) + 1 {
+			tok := l.NextToken()
+			if tok.Pos.Offset < end || tok.End.Offset < tok.Pos.Offset || tok.End.Offset > len(input) {
+				t.Fatalf("%q: token %s %q has bad range %d-%d after offset %d",
+					input, tok.Type, tok.Literal, tok.Pos.Offset, tok.End.Offset, end)
+			}
+			if tok.Type == token.EOF {
+				return
+			}
+			if tok.End.Offset == tok.Pos.Offset {
+				t.Fatalf("%q: empty %s token at offset %d", input, tok.Type, tok.Pos.Offset)
+			}
+			end = tok.End.Offset
+		}
+		t.Fatalf("%q: no EOF token", input)
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input   string
+		code    diag.Code
+		pos     token.Position
+		fixText string
+	}{
+		{"1 @ 2", diag.IllegalCharacter, token.Position{Offset: 2, Line: 1, Column: 3}, ""},
+		{"a & b", diag.IllegalCharacter, token.Position{Offset: 2, Line: 1, Column: 3}, "&&"},
+		{"a |\nb", diag.IllegalCharacter, token.Position{Offset: 2, Line: 1, Column: 3}, "||"},
+		{`x = "a\q"`, diag.InvalidString, token.Position{Offset: 4, Line: 1, Column: 5}, ""},
+		{"1 /* 2", diag.UnterminatedComment, token.Position{Offset: 2, Line: 1, Column: 3}, ""},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for l.NextToken().Type != token.EOF {
+		}
+
+		diags := l.Diagnostics()
+		if len(diags) != 1 {
+			t.Errorf("%q: expected 1 diagnostic, got=%d", tt.input, len(diags))
+			continue
+		}
+		d := diags[0]
+		if d.Severity != diag.Error || d.Code != tt.code || d.Pos != tt.pos {
+			t.Errorf("%q: wrong diagnostic. expected=%s at %+v, got=%s %s at %+v",
+				tt.input, tt.code, tt.pos, d.Severity, d.Code, d.Pos)
+		}
+		switch {
+		case tt.fixText == "" && d.Fix != nil:
+			t.Errorf("%q: unexpected fix %+v", tt.input, d.Fix)
+		case tt.fixText != "" && (d.Fix == nil || d.Fix.NewText != tt.fixText):
+			t.Errorf("%q: wrong fix. expected=%q, got=%+v", tt.input, tt.fixText, d.Fix)
+		}
+	}
+
+	if diags := New("let x = 1;").Diagnostics(); len(diags) != 0 {
+		t.Errorf("expected no diagnostics, got=%v", diags)
+	}
+}
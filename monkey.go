@@ -0,0 +1,244 @@
+// Package monkey runs Monkey programs from Go.
+//
+// A program is compiled once and can then be run any number of times:
+//
+//	prog, err := monkey.Compile(`let double = fn(x) { x * 2 }; double(n)`)
+//	if err != nil {
+//		return err // a *ParseError
+//	}
+//	result, err := prog.Run(ctx, monkey.WithGlobals(map[string]object.Object{
+//		"n": &object.Integer{Value: 21},
+//	}))
+//	if err != nil {
+//		return err // a *ParseError or *RuntimeError
+//	}
+//
+// The command line interpreter lives in cmd/monkey.
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/diag"
+	"github.com/pirosiki197/monkey/evaluator"
+	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/parser"
+	"github.com/pirosiki197/monkey/resolver"
+	"github.com/pirosiki197/monkey/token"
+)
+
+// Errors wrapped by a RuntimeError when a run is aborted.
+var (
+	ErrCancelled = evaluator.ErrCancelled
+	ErrStepLimit = evaluator.ErrStepLimit
+	ErrSizeLimit = evaluator.ErrSizeLimit
+)
+
+// Program is a parsed Monkey program. Its identifiers are resolved once,
+// so runs do not modify it and may proceed concurrently.
+type Program struct {
+	program *ast.Program
+	// unresolved lists the identifiers the program does not define;
+	// each run checks them against its globals and builtins.
+	unresolved []*resolver.Error
+}
+
+// Compile parses src. Syntax errors are reported as a *ParseError.
+func Compile(src string) (*Program, error) {
+	return CompileFile("", src)
+}
+
+// CompileFile is like Compile, with filename recorded in the positions of
+// errors.
+func CompileFile(filename, src string) (*Program, error) {
+	p := parser.New(lexer.NewWithFilename(filename, src))
+	program := p.ParseProgram()
+	if diags := p.Diagnostics(); len(diags) > 0 {
+		return nil, &ParseError{Diagnostics: diags}
+	}
+	unresolved := resolver.Resolve(program, func(string) bool { return false })
+	return &Program{program: program, unresolved: unresolved}, nil
+}
+
+// ParseError lists the syntax errors of a program, or the identifiers it
+// uses that are bound neither by the program nor by the globals and
+// builtins of a run. Use diag.RenderAll to show them with the source they
+// refer to.
+type ParseError struct {
+	Diagnostics []*diag.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Diagnostics[0].Error()
+	if len(e.Diagnostics) > 1 {
+		msg += fmt.Sprintf(" (and %d more errors)", len(e.Diagnostics)-1)
+	}
+	return msg
+}
+
+// RuntimeError is an error raised by a running program.
+type RuntimeError struct {
+	Message string
+	Pos     token.Position // where the error occurred, if known
+	// Stack lists the function calls the error propagated out of,
+	// innermost first.
+	Stack []object.StackFrame
+	// Err is the underlying Go error, if any, such as ErrCancelled,
+	// ErrStepLimit or ErrSizeLimit.
+	Err error
+}
+
+func newRuntimeError(err *object.Error) *RuntimeError {
+	return &RuntimeError{Message: err.Message, Pos: err.Pos, Stack: err.Stack, Err: err.Err}
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// Trace returns the error message followed by the stack trace, as
+// printed by the interpreter.
+func (e *RuntimeError) Trace() string {
+	obj := &object.Error{Message: e.Message, Pos: e.Pos, Stack: e.Stack}
+	return obj.Trace()
+}
+
+type config struct {
+	globals     map[string]object.Object
+	registry    *object.Registry
+	builtins    map[string]*object.Builtin
+	stdout      io.Writer
+	evalOptions []evaluator.Option
+}
+
+// Option configures a run of a program.
+type Option func(*config)
+
+// WithGlobals defines global variables. Programs can read and assign
+// them like variables defined by a top-level let statement.
+func WithGlobals(globals map[string]object.Object) Option {
+	return func(c *config) {
+		if c.globals == nil {
+			c.globals = make(map[string]object.Object)
+		}
+		for name, val := range globals {
+			c.globals[name] = val
+		}
+	}
+}
+
+// WithRegistry replaces the default builtins with those of r. The run
+// does not modify r.
+func WithRegistry(r *object.Registry) Option {
+	return func(c *config) {
+		c.registry = r
+	}
+}
+
+// WithBuiltins makes functions implemented in Go available to the
+// program under the given names, replacing the builtins of the same
+// name.
+func WithBuiltins(builtins map[string]*object.Builtin) Option {
+	return func(c *config) {
+		if c.builtins == nil {
+			c.builtins = make(map[string]*object.Builtin)
+		}
+		for name, fn := range builtins {
+			c.builtins[name] = fn
+		}
+	}
+}
+
+// WithStdout directs the output of puts to w instead of the standard
+// output.
+func WithStdout(w io.Writer) Option {
+	return func(c *config) {
+		c.stdout = w
+	}
+}
+
+// WithMaxSteps limits the number of evaluation steps of a run. A value
+// of zero or less disables the limit.
+func WithMaxSteps(steps int) Option {
+	return func(c *config) {
+		c.evalOptions = append(c.evalOptions, evaluator.WithMaxSteps(steps))
+	}
+}
+
+// WithMaxSize limits the size of the values a run creates: strings to
+// size bytes, and arrays and hashes to size elements. A value of zero or
+// less disables the limit.
+func WithMaxSize(size int) Option {
+	return func(c *config) {
+		c.evalOptions = append(c.evalOptions, evaluator.WithMaxSize(size))
+	}
+}
+
+// WithMaxCallDepth limits the depth of nested function calls. A value of
+// zero or less disables the limit.
+func WithMaxCallDepth(depth int) Option {
+	return func(c *config) {
+		c.evalOptions = append(c.evalOptions, evaluator.WithMaxCallDepth(depth))
+	}
+}
+
+// Run runs the program until it completes or ctx is done and returns the
+// value of its final expression statement, or NULL if there is none.
+// Identifiers that are not bound for the run are reported as a
+// *ParseError before anything runs, runtime errors as a *RuntimeError.
+func (p *Program) Run(ctx context.Context, opts ...Option) (object.Object, error) {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	registry := c.registry
+	if registry == nil {
+		registry = object.DefaultRegistry()
+	} else {
+		registry = registry.Clone()
+	}
+	if c.stdout != nil && registry.Lookup("puts") != nil {
+		registry.Add(object.Puts(c.stdout))
+	}
+	for name, fn := range c.builtins {
+		named := *fn
+		named.Name = name
+		registry.Add(&named)
+	}
+
+	env := object.NewEnvironment()
+	for name, val := range c.globals {
+		env.Set(name, val)
+	}
+
+	var diags []*diag.Diagnostic
+	for _, u := range p.unresolved {
+		if _, ok := env.Get(u.Name); !ok && registry.Lookup(u.Name) == nil {
+			diags = append(diags, u.Diagnostic())
+		}
+	}
+	if len(diags) > 0 {
+		return nil, &ParseError{Diagnostics: diags}
+	}
+
+	e := evaluator.NewWithEnv(env, append(c.evalOptions,
+		evaluator.WithBuiltins(registry), evaluator.WithContext(ctx), evaluator.WithResolvedPrograms())...)
+	result := e.Eval(p.program)
+	if err, ok := result.(*object.Error); ok {
+		return nil, newRuntimeError(err)
+	}
+	if result == nil {
+		result = object.NULL
+	}
+	return result, nil
+}
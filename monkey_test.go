@@ -0,0 +1,191 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"unique"
+
+	"github.com/pirosiki197/monkey/diag"
+	"github.com/pirosiki197/monkey/object"
+)
+
+func TestCompileError(t *testing.T) {
+	_, err := CompileFile("script.mk", "let = 1;\nlet x 2;")
+
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("error is not %T. got=%T (%v)", perr, err, err)
+	}
+	if len(perr.Diagnostics) < 2 {
+		t.Fatalf("expected at least 2 errors. got=%v", perr.Diagnostics)
+	}
+	first := perr.Diagnostics[0]
+	if first.Pos.Filename != "script.mk" || first.Pos.Line != 1 || first.Pos.Column != 5 {
+		t.Errorf("wrong position of the first error. got=%s", first.Pos)
+	}
+	if first.Code != diag.UnexpectedToken {
+		t.Errorf("wrong code of the first error. got=%s", first.Code)
+	}
+	if !strings.HasPrefix(err.Error(), "script.mk:1:5: expected next token to be IDENT") {
+		t.Errorf("wrong message. got=%q", err.Error())
+	}
+}
+
+func TestRun(t *testing.T) {
+	prog, err := Compile(`
+let greeting = greet(name);
+puts(greeting);
+count = count + 1;
+len(greeting) + count`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	greet := &object.Builtin{Arity: 1, Fn: func(args ...object.Object) object.Object {
+		name := args[0].(*object.String).Value.Value()
+		return &object.String{Value: unique.Make("hello, " + name)}
+	}}
+
+	for i := range 2 {
+		result, err := prog.Run(context.Background(),
+			WithGlobals(map[string]object.Object{
+				"name":  &object.String{Value: unique.Make("monkey")},
+				"count": &object.Integer{Value: int64(i)},
+			}),
+			WithBuiltins(map[string]*object.Builtin{"greet": greet}),
+			WithStdout(&out))
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if got := result.Inspect(); got != []string{"14", "15"}[i] {
+			t.Errorf("run %d: wrong result. got=%s", i, got)
+		}
+	}
+
+	if out.String() != "hello, monkey\nhello, monkey\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	prog, err := Compile(`puts("hi")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sandbox := object.DefaultRegistry()
+	sandbox.Remove("puts")
+	if _, err := prog.Run(context.Background(), WithRegistry(sandbox)); err == nil || err.Error() != "1:1: identifier not found: puts" {
+		t.Errorf("puts is available without being registered. err=%v", err)
+	}
+	if sandbox.Lookup("puts") != nil {
+		t.Errorf("Run modified the registry")
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	prog, err := Compile(`let sum = fn(n) { let s = 0; for (x in n) { s = s + x } s }; sum(xs)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			xs := &object.Array{Elements: []object.Object{&object.Integer{Value: int64(i)}, &object.Integer{Value: 1}}}
+			result, err := prog.Run(context.Background(), WithGlobals(map[string]object.Object{"xs": xs}))
+			if err != nil {
+				t.Errorf("run %d: %v", i, err)
+				return
+			}
+			if got, want := result.Inspect(), fmt.Sprint(i+1); got != want {
+				t.Errorf("run %d: wrong result. want=%s, got=%s", i, want, got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := prog.Run(context.Background()); err == nil || err.Error() != "1:66: identifier not found: xs" {
+		t.Errorf("missing global not reported. err=%v", err)
+	}
+}
+
+func TestRunWithoutValue(t *testing.T) {
+	prog, err := Compile("let a = 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := prog.Run(context.Background())
+	if err != nil || result != object.NULL {
+		t.Errorf("Run() = %v, %v. want null", result, err)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+	prog, err := CompileFile("script.mk", "let f = fn(x) {\n  x / 0\n};\nf(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = prog.Run(context.Background())
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("error is not %T. got=%T (%v)", rerr, err, err)
+	}
+	if rerr.Message != "division by zero" || rerr.Pos.Line != 2 || rerr.Pos.Column != 3 {
+		t.Errorf("wrong error. got=%q at %s", rerr.Message, rerr.Pos)
+	}
+	if len(rerr.Stack) != 1 || rerr.Stack[0].Function != "f" {
+		t.Errorf("wrong stack. got=%v", rerr.Stack)
+	}
+
+	expected := "script.mk:2:3: division by zero\n    at f/1 (script.mk:4:1)"
+	if rerr.Trace() != expected {
+		t.Errorf("wrong trace.\nwant=%q\ngot= %q", expected, rerr.Trace())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		input    string
+		ctx      context.Context
+		opts     []Option
+		expected error
+	}{
+		{"steps", "while (true) { }", context.Background(), []Option{WithMaxSteps(100)}, ErrStepLimit},
+		{"size", `let s = "x"; while (true) { s = s + s }`, context.Background(), []Option{WithMaxSteps(10000), WithMaxSize(1 << 20)}, ErrSizeLimit},
+		{"cancelled", "1", cancelled, nil, ErrCancelled},
+		{"call_depth", "let f = fn() { f() }; f()", context.Background(), []Option{WithMaxCallDepth(10)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := Compile(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = prog.Run(tt.ctx, tt.opts...)
+			var rerr *RuntimeError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("error is not %T. got=%T (%v)", rerr, err, err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("errors.Is(%q, %q) is false", err, tt.expected)
+			}
+			if tt.name == "call_depth" && rerr.Message != "maximum call depth of 10 exceeded" {
+				t.Errorf("wrong message. got=%q", rerr.Message)
+			}
+		})
+	}
+}
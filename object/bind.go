@@ -0,0 +1,99 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeFor[error]()
+
+// BuiltinFunc wraps the Go function fn into a builtin called name.
+//
+// The arguments are converted to the parameter types of fn as by Decode,
+// and the results back to objects as by FromGo. fn may return no value,
+// one value, or a value and an error; a non-nil error becomes an error
+// object. A variadic fn gives a variadic builtin.
+//
+// BuiltinFunc reports an error if fn is not a function or uses types it
+// cannot convert.
+func BuiltinFunc(name string, fn any) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return nil, fmt.Errorf("builtin %s: %T is not a function", name, fn)
+	}
+	t := v.Type()
+
+	for i := range t.NumIn() {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		if !convertible(in) {
+			return nil, fmt.Errorf("builtin %s: unsupported parameter type %s", name, in)
+		}
+	}
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	switch {
+	case t.NumOut() > 2, t.NumOut() == 2 && !returnsError:
+		return nil, fmt.Errorf("builtin %s: results must be (), (T), (error) or (T, error), got %s", name, t)
+	case t.NumOut() == 2 || t.NumOut() == 1 && !returnsError:
+		if !convertible(t.Out(0)) {
+			return nil, fmt.Errorf("builtin %s: unsupported result type %s", name, t.Out(0))
+		}
+	}
+
+	b := &Builtin{Name: name, Arity: t.NumIn()}
+	switch {
+	case t.IsVariadic():
+		b.Arity = Variadic
+	case t.NumIn() == 0:
+		b.Arity = NoArguments
+	}
+	b.Fn = func(args ...Object) Object {
+		if t.IsVariadic() && len(args) < t.NumIn()-1 {
+			return newError("wrong number of arguments. expected at least %d but got %d", t.NumIn()-1, len(args))
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			typ := t.In(min(i, t.NumIn()-1))
+			if t.IsVariadic() && i >= t.NumIn()-1 {
+				typ = typ.Elem()
+			}
+			val, err := toValue(arg, typ)
+			if err != nil {
+				return newError("argument %d to `%s`%s", i+1, name, err.describe())
+			}
+			in[i] = val
+		}
+
+		out := v.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &Error{Message: err.Error(), Err: err}
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return NULL
+		}
+		result, err := fromValue(out[0])
+		if err != nil {
+			return newError("result of `%s`%s", name, err.describe())
+		}
+		return result
+	}
+	return b, nil
+}
+
+// RegisterFunc adds the Go function fn as a builtin, as described by
+// BuiltinFunc.
+func (r *Registry) RegisterFunc(name, doc string, fn any) (*Builtin, error) {
+	b, err := BuiltinFunc(name, fn)
+	if err != nil {
+		return nil, err
+	}
+	b.Doc = doc
+	r.Add(b)
+	return b, nil
+}
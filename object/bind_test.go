@@ -0,0 +1,139 @@
+package object
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"unique"
+)
+
+func str(s string) *String { return &String{Value: unique.Make(s)} }
+
+func TestBuiltinFunc(t *testing.T) {
+	errNegative := errors.New("count must not be negative")
+	repeat, err := BuiltinFunc("repeat", func(s string, n int64) (string, error) {
+		if n < 0 {
+			return "", errNegative
+		}
+		return strings.Repeat(s, int(n)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := BuiltinFunc("sum", func(first int, rest ...int) int {
+		for _, n := range rest {
+			first += n
+		}
+		return first
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := BuiltinFunc("keys", func(m map[string][]int8) []string {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+		return keys
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toByte, err := BuiltinFunc("byte", func(b uint8) uint8 { return b })
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge, err := BuiltinFunc("huge", func() uint64 { return math.MaxUint64 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := BuiltinFunc("first", func(a *Array) Object {
+		if len(a.Elements) == 0 {
+			return nil
+		}
+		return a.Elements[0]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	hash := func(key Hashable, value Object) *Hash {
+		return &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: value}}}
+	}
+
+	tests := []struct {
+		builtin  *Builtin
+		args     []Object
+		expected string
+	}{
+		{repeat, []Object{str("ab"), two}, "abab"},
+		{repeat, []Object{str("ab")}, "ERROR: wrong number of arguments. expected 2 but got 1"},
+		{repeat, []Object{two, two}, "ERROR: argument 1 to `repeat` must be STRING, got INTEGER"},
+		{repeat, []Object{str("ab"), &Integer{Value: -1}}, "ERROR: count must not be negative"},
+		{sum, []Object{one}, "1"},
+		{sum, []Object{one, two, two}, "5"},
+		{sum, nil, "ERROR: wrong number of arguments. expected at least 1 but got 0"},
+		{sum, []Object{one, TRUE}, "ERROR: argument 2 to `sum` must be INTEGER, got BOOLEAN"},
+		{keys, []Object{hash(str("a"), &Array{Elements: []Object{one}})}, "[a]"},
+		{keys, []Object{hash(str("a"), &Array{Elements: []Object{one, str("x")}})}, "ERROR: argument 1 to `keys`: value at [a][1] must be INTEGER, got STRING"},
+		{keys, []Object{hash(str("a"), &Array{Elements: []Object{&Integer{Value: 300}}})}, "ERROR: argument 1 to `keys`: value at [a][0] overflows int8: 300"},
+		{keys, []Object{hash(one, &Array{})}, "ERROR: argument 1 to `keys`: value at key 1 must be STRING, got INTEGER"},
+		{toByte, []Object{&Integer{Value: -1}}, "ERROR: argument 1 to `byte` overflows uint8: -1"},
+		{huge, nil, "ERROR: result of `huge` overflows INTEGER: 18446744073709551615"},
+		{huge, []Object{one}, "ERROR: wrong number of arguments. expected 0 but got 1"},
+		{first, []Object{&Array{Elements: []Object{two}}}, "2"},
+		{first, []Object{&Array{}}, "null"},
+		{first, []Object{two}, "ERROR: argument 1 to `first` must be ARRAY, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.builtin.Call(tt.args...).Inspect(); got != tt.expected {
+			t.Errorf("%s%v: wrong result. expected=%q, got=%q", tt.builtin.Name, tt.args, tt.expected, got)
+		}
+	}
+
+	result := repeat.Call(str("ab"), &Integer{Value: -1})
+	if err, ok := result.(*Error); !ok || !errors.Is(err, errNegative) {
+		t.Errorf("the Go error is not wrapped. got=%#v", result)
+	}
+}
+
+func TestBuiltinFuncUnsupported(t *testing.T) {
+	tests := []struct {
+		fn       any
+		expected string
+	}{
+		{42, "builtin f: int is not a function"},
+		{func(float64) {}, "builtin f: unsupported parameter type float64"},
+		{func(...chan int) {}, "builtin f: unsupported parameter type chan int"},
+		{func(struct{ F float32 }) {}, "builtin f: unsupported parameter type struct { F float32 }"},
+		{func() (int, int) { return 0, 0 }, "builtin f: results must be (), (T), (error) or (T, error), got func() (int, int)"},
+		{func() chan int { return nil }, "builtin f: unsupported result type chan int"},
+	}
+
+	for _, tt := range tests {
+		_, err := BuiltinFunc("f", tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. expected=%q, got=%v", tt.expected, err)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	r := NewRegistry()
+	b, err := r.RegisterFunc("upper", "upper(s) returns s in upper case.", strings.ToUpper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Lookup("upper") != b || b.Arity != 1 || b.Doc == "" {
+		t.Errorf("wrong registered builtin: %+v", b)
+	}
+	if got := b.Call(str("monkey")).Inspect(); got != "MONKEY" {
+		t.Errorf("wrong result. got=%q", got)
+	}
+	if _, err := r.RegisterFunc("bad", "", func(complex64) {}); err == nil || r.Lookup("bad") != nil {
+		t.Errorf("unsupported function was registered")
+	}
+}
@@ -0,0 +1,76 @@
+package object
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// builtins lists the standard builtin functions. The compiler refers to
+// them by their index, so new builtins must be appended.
+var builtins = []*Builtin{
+	{
+		Name:  "len",
+		Arity: 1,
+		Doc:   "len(x) returns the number of bytes of a string, elements of an array or pairs of a hash.",
+		Fn: func(args ...Object) Object {
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(len(arg.Value.Value()))}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *Hash:
+				return &Integer{Value: int64(len(arg.Pairs))}
+			default:
+				return newError("argument to `len` not supported, got %s", arg.Type())
+			}
+		},
+	},
+	{
+		Name:  "puts",
+		Arity: Variadic,
+		Doc:   putsDoc,
+		Fn: func(args ...Object) Object {
+			return puts(os.Stdout, args)
+		},
+	},
+}
+
+// StandardBuiltins returns copies of the standard builtins in the order
+// the compiler numbers them. Callers may modify the copies freely.
+func StandardBuiltins() []*Builtin {
+	result := make([]*Builtin, len(builtins))
+	for i, b := range builtins {
+		c := *b
+		result[i] = &c
+	}
+	return result
+}
+
+const putsDoc = "puts(args...) prints each argument on a line of its own and returns null."
+
+// Puts returns a puts builtin that writes to w instead of the standard
+// output.
+func Puts(w io.Writer) *Builtin {
+	return &Builtin{
+		Name:  "puts",
+		Arity: Variadic,
+		Doc:   putsDoc,
+		Fn: func(args ...Object) Object {
+			return puts(w, args)
+		},
+	}
+}
+
+func puts(w io.Writer, args []Object) Object {
+	for _, arg := range args {
+		if _, err := fmt.Fprintln(w, arg.Inspect()); err != nil {
+			return &Error{Message: "puts: " + err.Error(), Err: err}
+		}
+	}
+	return NULL
+}
+
+func newError(format string, a ...any) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,462 @@
+package object
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unique"
+)
+
+var (
+	objectType = reflect.TypeFor[Object]()
+	anyType    = reflect.TypeFor[any]()
+)
+
+// FromGo converts the Go value v to an object.
+//
+// Integers become INTEGER, strings STRING and bools BOOLEAN. Slices and
+// arrays become ARRAY, maps HASH, and structs a HASH keyed by the names
+// of their exported fields, as changed by `monkey` struct tags (see
+// Decode). nil, nil pointers and nil interfaces become NULL; other
+// pointers and interfaces are converted as the value they refer to.
+// Objects are returned as is.
+//
+// Values of other types, such as floats, channels and functions, cannot
+// be converted, and neither can values referring back to themselves
+// through pointers, maps or slices; the error is a *ConversionError.
+func FromGo(v any) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	obj, err := fromValue(reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ToGo converts obj to its natural Go value: INTEGER gives an int64,
+// STRING a string, BOOLEAN a bool and NULL nil. ARRAY gives an []any, and
+// HASH a map[string]any if all its keys are strings and a map[any]any
+// otherwise. Other objects, such as functions, are returned as is.
+func ToGo(obj Object) any {
+	if obj == nil {
+		return nil
+	}
+	v, err := toValue(obj, anyType)
+	if err != nil {
+		// Every object converts to an empty interface.
+		panic(err)
+	}
+	return v.Interface()
+}
+
+// Decode converts obj to the Go value pointed to by v, which must be a
+// non-nil pointer. It is the inverse of FromGo: INTEGER converts to any
+// integer type that can represent its value, STRING to a string, BOOLEAN
+// to a bool, ARRAY to a slice or array and HASH to a map or struct.
+// Pointers are allocated as needed, and NULL converts to the zero value of
+// pointers, slices, maps and interfaces. Values converted to an empty
+// interface get the type ToGo gives them; variables of a type
+// implementing Object receive obj itself.
+//
+// A HASH is converted to a struct field by field. The key of a field is
+// its name unless a `monkey` struct tag gives another:
+//
+//	Name  string `monkey:"name"`      // key "name"
+//	Debug bool   `monkey:",omitempty"` // key "Debug", omitted by FromGo if false
+//	Cache []int  `monkey:"-"`         // not converted
+//
+// Keys without a matching field are ignored. If obj does not fit v, the
+// error is a *ConversionError locating the offending value.
+func Decode(obj Object, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("object: Decode of non-pointer or nil %T", v)
+	}
+	if !convertible(rv.Type().Elem()) {
+		return fmt.Errorf("object: Decode into unsupported type %s", rv.Type().Elem())
+	}
+	val, err := toValue(obj, rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(val)
+	return nil
+}
+
+// ConversionError describes a value that could not be converted between
+// an object and a Go value.
+type ConversionError struct {
+	// Path locates the offending value within nested arrays, hashes and
+	// structs, as in "[users][0][name]"; it is empty for the converted
+	// value itself.
+	Path string
+	Msg  string
+}
+
+func (e *ConversionError) Error() string {
+	if e.Path == "" {
+		return "value " + e.Msg
+	}
+	return "value at " + e.Path + " " + e.Msg
+}
+
+// describe formats the error to follow a description of the converted
+// value, as in "argument 1 to `f`: value at [0] must be STRING, got NULL".
+func (e *ConversionError) describe() string {
+	if e.Path == "" {
+		return " " + e.Msg
+	}
+	return ": " + e.Error()
+}
+
+func (e *ConversionError) within(path string) *ConversionError {
+	e.Path = path + e.Path
+	return e
+}
+
+func mismatch(want string, got Object) *ConversionError {
+	return &ConversionError{Msg: fmt.Sprintf("must be %s, got %s", want, got.Type())}
+}
+
+// convertible reports whether values of type t can be converted from and
+// to objects.
+func convertible(t reflect.Type) bool {
+	return convertibleType(t, make(map[reflect.Type]bool))
+}
+
+// convertibleType implements convertible. seen holds the struct types
+// being checked, which are assumed convertible so recursive types
+// terminate.
+func convertibleType(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if t.Implements(objectType) || t == objectType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.String, reflect.Bool:
+		return true
+	case reflect.Interface:
+		return t.NumMethod() == 0
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return convertibleType(t.Elem(), seen)
+	case reflect.Map:
+		return convertibleType(t.Key(), seen) && convertibleType(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return true
+		}
+		seen[t] = true
+		for _, f := range structFields(t) {
+			if !convertibleType(t.FieldByIndex(f.index).Type, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// field is an exported struct field converted to and from a hash pair.
+type field struct {
+	index     []int
+	key       string
+	omitEmpty bool
+}
+
+// structFields returns the convertible fields of the struct type t.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("monkey")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, field{index: f.Index, key: name, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+// toValue converts obj to a Go value of type t.
+func toValue(obj Object, t reflect.Type) (reflect.Value, *ConversionError) {
+	if obj == nil {
+		return reflect.Value{}, &ConversionError{Msg: "is a nil Object"}
+	}
+	if t == objectType || t.Implements(objectType) {
+		if reflect.TypeOf(obj).AssignableTo(t) {
+			return reflect.ValueOf(obj), nil
+		}
+		return reflect.Value{}, mismatch(objectTypeName(t), obj)
+	}
+
+	v := reflect.New(t).Elem()
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			return v, nil
+		}
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*Integer)
+		if !ok {
+			return reflect.Value{}, mismatch(INTEGER_OBJ.String(), obj)
+		}
+		if v.OverflowInt(i.Value) {
+			return reflect.Value{}, &ConversionError{Msg: fmt.Sprintf("overflows %s: %d", t, i.Value)}
+		}
+		v.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, ok := obj.(*Integer)
+		if !ok {
+			return reflect.Value{}, mismatch(INTEGER_OBJ.String(), obj)
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return reflect.Value{}, &ConversionError{Msg: fmt.Sprintf("overflows %s: %d", t, i.Value)}
+		}
+		v.SetUint(uint64(i.Value))
+	case reflect.String:
+		s, ok := obj.(*String)
+		if !ok {
+			return reflect.Value{}, mismatch(STRING_OBJ.String(), obj)
+		}
+		v.SetString(s.Value.Value())
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return reflect.Value{}, mismatch(BOOLEAN_OBJ.String(), obj)
+		}
+		v.SetBool(b.Value)
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return reflect.Value{}, &ConversionError{Msg: fmt.Sprintf("cannot be converted to %s", t)}
+		}
+		natural, err := naturalValue(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if natural.IsValid() {
+			v.Set(natural)
+		}
+	case reflect.Pointer:
+		elem, err := toValue(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(elem)
+	case reflect.Slice, reflect.Array:
+		array, ok := obj.(*Array)
+		if !ok {
+			return reflect.Value{}, mismatch(ARRAY_OBJ.String(), obj)
+		}
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, len(array.Elements), len(array.Elements)))
+		} else if len(array.Elements) != t.Len() {
+			return reflect.Value{}, &ConversionError{Msg: fmt.Sprintf("must have %d elements, got %d", t.Len(), len(array.Elements))}
+		}
+		for i, el := range array.Elements {
+			elem, err := toValue(el, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err.within(fmt.Sprintf("[%d]", i))
+			}
+			v.Index(i).Set(elem)
+		}
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return reflect.Value{}, mismatch(HASH_OBJ.String(), obj)
+		}
+		v.Set(reflect.MakeMapWithSize(t, len(hash.Pairs)))
+		for _, pair := range hash.Pairs {
+			key, err := toValue(pair.Key, t.Key())
+			if err != nil {
+				return reflect.Value{}, err.within(fmt.Sprintf("key %s", pair.Key.Inspect()))
+			}
+			val, err := toValue(pair.Value, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err.within(fmt.Sprintf("[%s]", pair.Key.Inspect()))
+			}
+			v.SetMapIndex(key, val)
+		}
+	case reflect.Struct:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return reflect.Value{}, mismatch(HASH_OBJ.String(), obj)
+		}
+		for _, f := range structFields(t) {
+			pair, ok := hash.Pairs[(&String{Value: unique.Make(f.key)}).HashKey()]
+			if !ok {
+				continue
+			}
+			val, err := toValue(pair.Value, t.FieldByIndex(f.index).Type)
+			if err != nil {
+				return reflect.Value{}, err.within(fmt.Sprintf("[%s]", f.key))
+			}
+			v.FieldByIndex(f.index).Set(val)
+		}
+	default:
+		return reflect.Value{}, &ConversionError{Msg: fmt.Sprintf("cannot be converted to %s", t)}
+	}
+	return v, nil
+}
+
+// naturalValue converts obj to the Go value ToGo documents. It returns
+// the zero Value for NULL.
+func naturalValue(obj Object) (reflect.Value, *ConversionError) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return reflect.ValueOf(obj.Value), nil
+	case *String:
+		return reflect.ValueOf(obj.Value.Value()), nil
+	case *Boolean:
+		return reflect.ValueOf(obj.Value), nil
+	case *Null:
+		return reflect.Value{}, nil
+	case *Array:
+		return toValue(obj, reflect.TypeFor[[]any]())
+	case *Hash:
+		for _, pair := range obj.Pairs {
+			if _, ok := pair.Key.(*String); !ok {
+				return toValue(obj, reflect.TypeFor[map[any]any]())
+			}
+		}
+		return toValue(obj, reflect.TypeFor[map[string]any]())
+	}
+	return reflect.ValueOf(obj), nil
+}
+
+// fromValue converts the Go value v to an object.
+func fromValue(v reflect.Value) (Object, *ConversionError) {
+	return fromValueVisiting(v, make(map[visit]bool))
+}
+
+// visit identifies a pointer, map or slice being converted. Slices
+// sharing an array but differing in length are different values.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// fromValueVisiting implements fromValue. visiting holds the pointers,
+// maps and slices enclosing v, so a value referring back to one of them
+// is reported instead of being converted forever.
+func fromValueVisiting(v reflect.Value, visiting map[visit]bool) (Object, *ConversionError) {
+	if v.Type() == objectType || v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer) && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if visiting[key] {
+			return nil, &ConversionError{Msg: fmt.Sprintf("forms a cycle through %s", v.Type())}
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := v.Uint()
+		if u > 1<<63-1 {
+			return nil, &ConversionError{Msg: fmt.Sprintf("overflows INTEGER: %d", u)}
+		}
+		return &Integer{Value: int64(u)}, nil
+	case reflect.String:
+		return &String{Value: unique.Make(v.String())}, nil
+	case reflect.Bool:
+		return nativeBool(v.Bool()), nil
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValueVisiting(v.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			el, err := fromValueVisiting(v.Index(i), visiting)
+			if err != nil {
+				return nil, err.within(fmt.Sprintf("[%d]", i))
+			}
+			elements[i] = el
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		pairs := make(map[HashKey]HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromValueVisiting(iter.Key(), visiting)
+			if err != nil {
+				return nil, err.within(fmt.Sprintf("key %v", iter.Key()))
+			}
+			hashable, ok := key.(Hashable)
+			if !ok {
+				return nil, &ConversionError{Path: "key " + key.Inspect(), Msg: fmt.Sprintf("is unusable as hash key: %s", key.Type())}
+			}
+			val, err := fromValueVisiting(iter.Value(), visiting)
+			if err != nil {
+				return nil, err.within(fmt.Sprintf("[%s]", key.Inspect()))
+			}
+			pairs[hashable.HashKey()] = HashPair{Key: key, Value: val}
+		}
+		return &Hash{Pairs: pairs}, nil
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		pairs := make(map[HashKey]HashPair, len(fields))
+		for _, f := range fields {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			val, err := fromValueVisiting(fv, visiting)
+			if err != nil {
+				return nil, err.within(fmt.Sprintf("[%s]", f.key))
+			}
+			key := &String{Value: unique.Make(f.key)}
+			pairs[key.HashKey()] = HashPair{Key: key, Value: val}
+		}
+		return &Hash{Pairs: pairs}, nil
+	}
+	return nil, &ConversionError{Msg: fmt.Sprintf("has unsupported type %s", v.Type())}
+}
+
+// objectTypeName names the objects of type t, which implements Object,
+// in error messages.
+func objectTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Pointer {
+		if obj, ok := reflect.New(t.Elem()).Interface().(Object); ok {
+			return obj.Type().String()
+		}
+	}
+	return "any value"
+}
+
+func nativeBool(b bool) *Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
@@ -0,0 +1,237 @@
+package object
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type user struct {
+	Name    string            `monkey:"name"`
+	Age     uint8             `monkey:"age"`
+	Admin   bool              `monkey:"admin,omitempty"`
+	Tags    []string          `monkey:"tags"`
+	Manager *user             `monkey:"manager"`
+	Extra   map[string]Object `monkey:"extra,omitempty"`
+	Ignored chan int          `monkey:"-"`
+	hidden  int
+}
+
+func TestFromGo(t *testing.T) {
+	n := 3
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{42, "42"},
+		{uint16(7), "7"},
+		{&n, "3"},
+		{(*int)(nil), "null"},
+		{"monkey", "monkey"},
+		{true, "true"},
+		{[]any{1, "a", nil, []int{2}}, "[1, a, null, [2]]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[string]int{"b": 2, "a": 1}, "{a: 1, b: 2}"},
+		{map[int]any{1: map[bool]string{true: "yes"}}, "{1: {true: yes}}"},
+		{&Integer{Value: 5}, "5"},
+		{[]Object{TRUE, nil}, "[true, null]"},
+		{
+			user{Name: "ann", Age: 30, Tags: []string{"x"}, Manager: &user{Name: "bob", Admin: true}},
+			"{age: 30, manager: {admin: true, age: 0, manager: null, name: bob, tags: []}, name: ann, tags: [x]}",
+		},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) returned error: %v", tt.input, err)
+			continue
+		}
+		if got := obj.Inspect(); got != tt.expected {
+			t.Errorf("FromGo(%#v): expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{1.5, "value has unsupported type float64"},
+		{uint64(1 << 63), "value overflows INTEGER: 9223372036854775808"},
+		{[]any{1, []any{make(chan int)}}, "value at [1][0] has unsupported type chan int"},
+		{map[string]any{"f": func() {}}, "value at [f] has unsupported type func()"},
+		{struct{ Ratio float32 }{}, "value at [Ratio] has unsupported type float32"},
+	}
+
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		var cerr *ConversionError
+		if !errors.As(err, &cerr) {
+			t.Errorf("FromGo(%#v): error is not %T. got=%v", tt.input, cerr, err)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("FromGo(%#v): expected=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+
+	_, err := FromGo(map[any]int{&Array{}: 1})
+	if err == nil || err.Error() != "value at key [] is unusable as hash key: ARRAY" {
+		t.Errorf("wrong error for unhashable key. got=%v", err)
+	}
+}
+
+func TestFromGoCycles(t *testing.T) {
+	boss := &user{Name: "boss"}
+	boss.Manager = boss
+	m := map[string]any{}
+	m["self"] = m
+	s := []any{1, nil}
+	s[1] = s
+
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{boss, "value at [manager] forms a cycle through *object.user"},
+		{m, "value at [self] forms a cycle through map[string]interface {}"},
+		{s, "value at [1] forms a cycle through []interface {}"},
+	}
+
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		var cerr *ConversionError
+		if !errors.As(err, &cerr) || err.Error() != tt.expected {
+			t.Errorf("FromGo(%T): expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// a value referred to twice without a cycle is converted twice
+	shared := &user{Name: "shared"}
+	obj := mustFromGo(t, []*user{shared, shared, {Manager: shared}})
+	if got := len(obj.(*Array).Elements); got != 3 {
+		t.Errorf("wrong number of elements. got=%d", got)
+	}
+	if _, err := FromGo(s[:1]); err != nil {
+		t.Errorf("prefix of a cyclic slice not converted. got=%v", err)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	fn := &Function{}
+	tests := []struct {
+		input    Object
+		expected any
+	}{
+		{&Integer{Value: 1}, int64(1)},
+		{str("a"), "a"},
+		{FALSE, false},
+		{NULL, nil},
+		{&Array{Elements: []Object{&Integer{Value: 1}, NULL}}, []any{int64(1), nil}},
+		{mustFromGo(t, map[string]any{"a": []int{1}}), map[string]any{"a": []any{int64(1)}}},
+		{mustFromGo(t, map[any]any{1: "x", "y": true}), map[any]any{int64(1): "x", "y": true}},
+		{fn, fn},
+	}
+
+	for _, tt := range tests {
+		if got := ToGo(tt.input); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ToGo(%s): expected=%#v, got=%#v", tt.input.Inspect(), tt.expected, got)
+		}
+	}
+	if got := ToGo(nil); got != nil {
+		t.Errorf("ToGo(nil): expected=nil, got=%#v", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	input := mustFromGo(t, map[string]any{
+		"name":    "ann",
+		"age":     30,
+		"admin":   true,
+		"tags":    []string{"x", "y"},
+		"manager": map[string]any{"name": "bob", "manager": nil},
+		"extra":   map[string]any{"f": &Function{}},
+		"hidden":  1,
+		"unknown": 1,
+	})
+
+	var u user
+	if err := Decode(input, &u); err != nil {
+		t.Fatal(err)
+	}
+	expected := user{
+		Name:    "ann",
+		Age:     30,
+		Admin:   true,
+		Tags:    []string{"x", "y"},
+		Manager: &user{Name: "bob"},
+		Extra:   map[string]Object{"f": u.Extra["f"]},
+	}
+	if _, ok := u.Extra["f"].(*Function); !ok || !reflect.DeepEqual(u, expected) {
+		t.Errorf("wrong result.\nexpected=%+v\ngot=     %+v", expected, u)
+	}
+
+	var arr [2]int
+	if err := Decode(mustFromGo(t, []int{1, 2}), &arr); err != nil || arr != [2]int{1, 2} {
+		t.Errorf("Decode into array = %v, %v", arr, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		input    any
+		target   any
+		expected string
+	}{
+		{"x", new(int), "value must be INTEGER, got STRING"},
+		{300, new(int8), "value overflows int8: 300"},
+		{-1, new(uint), "value overflows uint: -1"},
+		{nil, new(bool), "value must be BOOLEAN, got NULL"},
+		{[]any{1, "x"}, new([]int), "value at [1] must be INTEGER, got STRING"},
+		{[]int{1}, new([2]int), "value must have 2 elements, got 1"},
+		{map[string]any{"name": 1}, new(user), "value at [name] must be STRING, got INTEGER"},
+		{map[string]any{"manager": map[string]any{"tags": []any{true}}}, new(user), "value at [manager][tags][0] must be STRING, got BOOLEAN"},
+		{map[int]int{1: 2}, new(map[string]int), "value at key 1 must be STRING, got INTEGER"},
+		{1, new(*Array), "value must be ARRAY, got INTEGER"},
+		{1, 0, "object: Decode of non-pointer or nil int"},
+		{1, (*int)(nil), "object: Decode of non-pointer or nil *int"},
+		{1, new(float64), "object: Decode into unsupported type float64"},
+	}
+
+	for _, tt := range tests {
+		err := Decode(mustFromGo(t, tt.input), tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Decode(%#v, %T): expected=%q, got=%v", tt.input, tt.target, tt.expected, err)
+		}
+	}
+
+	nilTests := []struct {
+		obj      Object
+		target   any
+		expected string
+	}{
+		{nil, new(int), "value is a nil Object"},
+		{nil, new(Object), "value is a nil Object"},
+		{nil, new(any), "value is a nil Object"},
+		{&Array{Elements: []Object{nil}}, new([]int), "value at [0] is a nil Object"},
+	}
+	for _, tt := range nilTests {
+		err := Decode(tt.obj, tt.target)
+		var cerr *ConversionError
+		if !errors.As(err, &cerr) || err.Error() != tt.expected {
+			t.Errorf("Decode(%v, %T): expected=%q, got=%v", tt.obj, tt.target, tt.expected, err)
+		}
+	}
+}
+
+func mustFromGo(t *testing.T, v any) Object {
+	t.Helper()
+	obj, err := FromGo(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
@@ -2,10 +2,13 @@ package object
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"unique"
 
 	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/token"
 )
 
 type ObjectType int
@@ -26,11 +29,38 @@ const (
 	FUNCTION_OBJ                // FUNCTION
 	ERROR_OBJ                   // ERROR
 	BUILTIN_OBJ                 // BUILTIN
+	ARRAY_OBJ                   // ARRAY
+	HASH_OBJ                    // HASH
+	BREAK_OBJ                   // BREAK
+	CONTINUE_OBJ                // CONTINUE
+
+	COMPILED_FUNCTION_OBJ // COMPILED_FUNCTION
+	CELL_OBJ              // CELL
+)
+
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
 )
 
+// Environment holds variable bindings. The global environment maps names
+// to values; enclosed environments, created for blocks and function
+// calls, hold a fixed number of slots indexed by the positions the
+// resolver assigns to local variables.
 type Environment struct {
 	store map[string]Object
+	slots []slot
 	outer *Environment
+	// global is the global environment e is enclosed in, or nil if e is
+	// global itself.
+	global *Environment
+}
+
+// slot is a local variable of an enclosed environment.
+type slot struct {
+	value Object
+	bound bool // set once the variable has been assigned
 }
 
 func NewEnvironment() *Environment {
@@ -39,34 +69,81 @@ func NewEnvironment() *Environment {
 	}
 }
 
-func NewEnclosedEnvironment(outer *Environment) *Environment {
+// NewEnclosedEnvironment returns an environment with size slots inside outer.
+func NewEnclosedEnvironment(outer *Environment, size int) *Environment {
 	return &Environment{
-		store: make(map[string]Object),
-		outer: outer,
+		slots:  make([]slot, size),
+		outer:  outer,
+		global: outer.globals(),
 	}
 }
 
-func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
-	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+// globals returns the global environment e is enclosed in, or e itself
+// if it is global.
+func (e *Environment) globals() *Environment {
+	if e.global != nil {
+		return e.global
 	}
+	return e
+}
+
+// Get looks up a binding of the global environment of e by name.
+func (e *Environment) Get(name string) (Object, bool) {
+	obj, ok := e.globals().store[name]
 	return obj, ok
 }
 
+// Set binds name in the global environment of e.
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	g := e.globals()
+	if g.store == nil {
+		g.store = make(map[string]Object)
+	}
+	g.store[name] = val
 	return val
 }
 
+// Update changes an existing binding of the global environment of e and
+// reports whether it exists.
 func (e *Environment) Update(name string, val Object) (Object, bool) {
-	if _, ok := e.store[name]; ok {
-		e.store[name] = val
-		return val, true
-	} else if e.outer != nil {
-		return e.outer.Update(name, val)
+	g := e.globals()
+	if _, ok := g.store[name]; !ok {
+		return nil, false
 	}
-	return nil, false
+	g.store[name] = val
+	return val, true
+}
+
+// GetAt returns the value in slot i of the environment depth levels up
+// and reports whether the slot has been assigned yet.
+func (e *Environment) GetAt(depth, i int) (Object, bool) {
+	for range depth {
+		e = e.outer
+	}
+	s := e.slots[i]
+	return s.value, s.bound
+}
+
+// SetAt assigns val to slot i of the environment depth levels up.
+func (e *Environment) SetAt(depth, i int, val Object) {
+	for range depth {
+		e = e.outer
+	}
+	e.slots[i] = slot{value: val, bound: true}
+}
+
+// HashKey identifies a hashable value inside a Hash.
+// Two objects have equal hash keys if and only if they are equal values.
+type HashKey struct {
+	typ   ObjectType
+	value uint64                // integers and booleans
+	str   unique.Handle[string] // strings
+}
+
+// Hashable is implemented by objects that can be used as hash keys.
+type Hashable interface {
+	Object
+	HashKey() HashKey
 }
 
 type Integer struct {
@@ -75,6 +152,9 @@ type Integer struct {
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
+func (i *Integer) HashKey() HashKey {
+	return HashKey{typ: i.Type(), value: uint64(i.Value)}
+}
 
 type String struct {
 	Value unique.Handle[string]
@@ -82,6 +162,9 @@ type String struct {
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value.Value() }
+func (s *String) HashKey() HashKey {
+	return HashKey{typ: s.Type(), str: s.Value}
+}
 
 type Boolean struct {
 	Value bool
@@ -89,6 +172,13 @@ type Boolean struct {
 
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{typ: b.Type(), value: value}
+}
 
 type Null struct{}
 
@@ -102,7 +192,22 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue signal a loop control statement while it
+// propagates out of the enclosing blocks to the loop.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
 type Function struct {
+	// Name is the name the function was declared or let-bound as, or
+	// empty for an anonymous function.
+	Name       string
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -118,6 +223,10 @@ func (f *Function) Inspect() string {
 	}
 
 	out.WriteString("fn")
+	if f.Name != "" {
+		out.WriteByte(' ')
+		out.WriteString(f.Name)
+	}
 	out.WriteByte('(')
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteByte(')')
@@ -128,18 +237,218 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Error is a runtime error. It also implements the error interface so
+// hosts can inspect it with errors.Is and errors.As; Err optionally holds
+// the underlying Go error.
 type Error struct {
 	Message string
+	Pos     token.Position // where the error occurred, if known
+	Err     error
+	// Stack lists the function calls the error propagated out of,
+	// innermost first.
+	Stack []StackFrame
 }
 
+// maxInspectedFrames bounds the number of stack frames Inspect prints.
+// The frames of deeper stacks are elided in the middle.
+const maxInspectedFrames = 20
+
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+
+func (e *Error) Inspect() string { return "ERROR: " + e.Trace() }
+
+// Trace returns the error message followed by the stack trace, one frame
+// per line.
+func (e *Error) Trace() string {
+	var out strings.Builder
+	out.WriteString(e.Error())
+	for i, frame := range e.Stack {
+		if len(e.Stack) > maxInspectedFrames {
+			omitted := len(e.Stack) - maxInspectedFrames
+			if i == maxInspectedFrames/2 {
+				fmt.Fprintf(&out, "\n    ... %d more frames", omitted)
+			}
+			if i >= maxInspectedFrames/2 && i < maxInspectedFrames/2+omitted {
+				continue
+			}
+		}
+		out.WriteString("\n    at ")
+		out.WriteString(frame.String())
+	}
+	return out.String()
+}
+
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// StackFrame describes a function call an error propagated out of.
+type StackFrame struct {
+	Function string         // name of the called function; empty if anonymous
+	Pos      token.Position // position of the call
+	NumArgs  int
+}
+
+// String formats the frame as name/arity followed by the call position,
+// as in "fib/1 (3:12)".
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if !f.Pos.IsValid() {
+		return fmt.Sprintf("%s/%d", name, f.NumArgs)
+	}
+	return fmt.Sprintf("%s/%d (%s)", name, f.NumArgs, f.Pos)
+}
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	elements := make([]string, len(a.Elements))
+	for i, e := range a.Elements {
+		elements[i] = e.Inspect()
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+// Inspect lists the pairs sorted by the inspected key, so the output
+// does not depend on map iteration order.
+func (h *Hash) Inspect() string {
+	pairs := make([]string, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	slices.Sort(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// CompiledFunction is the bytecode of a function literal.
+type CompiledFunction struct {
+	Name          string // empty for anonymous functions
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+
+	// CellParams lists the parameters captured by closures; they are
+	// moved into cells when the function is called.
+	CellParams []int
+	// Blocks holds, for each OpEnterBlock operand, the local slots that
+	// receive a fresh cell when the block is entered.
+	Blocks [][]int
+	// FreeNames holds the names of the free variables, by index.
+	FreeNames []string
+	// Positions maps instruction offsets to source positions, sorted by offset.
+	Positions []PosEntry
+}
+
+// PosEntry maps the instruction at Offset and all instructions up to
+// the next entry to Pos.
+type PosEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// PosAt returns the source position of the instruction at offset ip.
+func (cf *CompiledFunction) PosAt(ip int) token.Position {
+	i, found := slices.BinarySearchFunc(cf.Positions, ip, func(e PosEntry, ip int) int {
+		return e.Offset - ip
+	})
+	if !found {
+		i--
+	}
+	if i < 0 {
+		return token.Position{}
+	}
+	return cf.Positions[i].Pos
+}
+
+// Closure is a compiled function together with the cells of its free
+// variables. It has the same object type as Function.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// Cell holds a variable that is shared between a function and the
+// closures that capture it.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string {
+	if c.Value == nil {
+		return "cell()"
+	}
+	return "cell(" + c.Value.Inspect() + ")"
+}
 
 type BuiltinFunction func(args ...Object) Object
 
+// Arities of builtins that do not take a fixed positive number of
+// arguments. Variadic is the zero value, so a Builtin without an Arity
+// accepts any arguments.
+const (
+	Variadic    = 0  // any number of arguments
+	NoArguments = -1 // no arguments
+)
+
+// Builtin is a function implemented in Go.
 type Builtin struct {
-	Fn BuiltinFunction
+	Name string
+	// Arity is the number of arguments Fn expects, NoArguments or
+	// Variadic. Call checks it, so Fn does not have to.
+	Arity int
+	Doc   string
+	Fn    BuiltinFunction
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
-func (b *Builtin) Inspect() string  { return "builtin function" }
+func (b *Builtin) Inspect() string {
+	if b.Name == "" {
+		return "builtin function"
+	}
+	return "builtin function " + b.Name
+}
+
+// Call calls the builtin with args after checking their number. A nil
+// result of Fn is returned as NULL.
+func (b *Builtin) Call(args ...Object) Object {
+	if want := max(b.Arity, 0); b.Arity != Variadic && len(args) != want {
+		return newError("wrong number of arguments. expected %d but got %d", want, len(args))
+	}
+	if result := b.Fn(args...); result != nil {
+		return result
+	}
+	return NULL
+}
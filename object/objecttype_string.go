@@ -16,11 +16,17 @@ func _() {
 	_ = x[FUNCTION_OBJ-6]
 	_ = x[ERROR_OBJ-7]
 	_ = x[BUILTIN_OBJ-8]
+	_ = x[ARRAY_OBJ-9]
+	_ = x[HASH_OBJ-10]
+	_ = x[BREAK_OBJ-11]
+	_ = x[CONTINUE_OBJ-12]
+	_ = x[COMPILED_FUNCTION_OBJ-13]
+	_ = x[CELL_OBJ-14]
 }
 
-const _ObjectType_name = "INTEGERSTRINGBOOLEANNULLRETURN_VALUEFUNCTIONERRORBUILTIN"
+const _ObjectType_name = "INTEGERSTRINGBOOLEANNULLRETURN_VALUEFUNCTIONERRORBUILTINARRAYHASHBREAKCONTINUECOMPILED_FUNCTIONCELL"
 
-var _ObjectType_index = [...]uint8{0, 7, 13, 20, 24, 36, 44, 49, 56}
+var _ObjectType_index = [...]uint8{0, 7, 13, 20, 24, 36, 44, 49, 56, 61, 65, 70, 78, 95, 99}
 
 func (i ObjectType) String() string {
 	i -= 1
@@ -0,0 +1,129 @@
+package object
+
+import (
+	"math"
+	"unique"
+)
+
+// The operators of the language, shared by the evaluator and the virtual
+// machine so both report the same results and errors. Errors are
+// returned as *Error without a position.
+
+// IsTruthy reports whether obj counts as true in a condition: everything
+// but false and null does.
+func IsTruthy(obj Object) bool {
+	return obj != FALSE && obj != NULL
+}
+
+// Prefix applies the prefix operator ! or - to right.
+func Prefix(operator string, right Object) Object {
+	switch operator {
+	case "!":
+		return nativeBool(!IsTruthy(right))
+	case "-":
+		integer, ok := right.(*Integer)
+		if !ok {
+			return newError("unknown operator: -%s", right.Type())
+		}
+		if integer.Value == math.MinInt64 {
+			return newError("integer overflow: -(%d)", integer.Value)
+		}
+		return &Integer{Value: -integer.Value}
+	default:
+		return newError("unknown operator: %s%s", operator, right.Type())
+	}
+}
+
+// Infix applies the infix operator to left and right, as in left + right.
+// The logical operators && and || are not included since they do not
+// evaluate their right operand unconditionally.
+func Infix(operator string, left, right Object) Object {
+	switch {
+	case both(left, right, INTEGER_OBJ):
+		return integerInfix(operator, left.(*Integer).Value, right.(*Integer).Value)
+	case both(left, right, STRING_OBJ):
+		return stringInfix(operator, left.(*String).Value, right.(*String).Value)
+	case operator == "==":
+		return nativeBool(left == right)
+	case operator == "!=":
+		return nativeBool(left != right)
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func integerInfix(operator string, left, right int64) Object {
+	switch operator {
+	case "+":
+		return &Integer{Value: left + right}
+	case "-":
+		return &Integer{Value: left - right}
+	case "*":
+		return &Integer{Value: left * right}
+	case "/":
+		if right == 0 {
+			return newError("division by zero")
+		}
+		return &Integer{Value: left / right}
+	case "<":
+		return nativeBool(left < right)
+	case ">":
+		return nativeBool(left > right)
+	case "<=":
+		return nativeBool(left <= right)
+	case ">=":
+		return nativeBool(left >= right)
+	case "==":
+		return nativeBool(left == right)
+	case "!=":
+		return nativeBool(left != right)
+	default:
+		return newError("unknown operator: %s %s %s", INTEGER_OBJ, operator, INTEGER_OBJ)
+	}
+}
+
+func stringInfix(operator string, left, right unique.Handle[string]) Object {
+	switch operator {
+	case "+":
+		return &String{Value: unique.Make(left.Value() + right.Value())}
+	case "==":
+		return nativeBool(left == right)
+	case "!=":
+		return nativeBool(left != right)
+	default:
+		return newError("unknown operator: %s %s %s", STRING_OBJ, operator, STRING_OBJ)
+	}
+}
+
+// Index returns the element of left at index, as in left[index]. A
+// missing hash key gives NULL.
+func Index(left, index Object) Object {
+	switch left := left.(type) {
+	case *Array:
+		idx, ok := index.(*Integer)
+		if !ok {
+			break
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d with length %d", idx.Value, len(left.Elements))
+		}
+		return left.Elements[idx.Value]
+	case *Hash:
+		key, ok := index.(Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		pair, ok := left.Pairs[key.HashKey()]
+		if !ok {
+			return NULL
+		}
+		return pair.Value
+	}
+	return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+}
+
+func both(left, right Object, objType ObjectType) bool {
+	return left.Type() == objType && right.Type() == objType
+}
@@ -0,0 +1,47 @@
+package object
+
+import (
+	"math"
+	"testing"
+	"unique"
+)
+
+func TestOperators(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	str := func(s string) *String { return &String{Value: unique.Make(s)} }
+	arr := &Array{Elements: []Object{one, two}}
+	hash := &Hash{Pairs: map[HashKey]HashPair{str("a").HashKey(): {Key: str("a"), Value: one}}}
+
+	tests := []struct {
+		name     string
+		result   Object
+		expected string
+	}{
+		{"add", Infix("+", one, two), "3"},
+		{"divide", Infix("/", two, one), "2"},
+		{"divide_by_zero", Infix("/", one, &Integer{Value: 0}), "ERROR: division by zero"},
+		{"compare", Infix("<=", two, one), "false"},
+		{"concat", Infix("+", str("a"), str("b")), "ab"},
+		{"string_equal", Infix("==", str("a"), str("a")), "true"},
+		{"string_minus", Infix("-", str("a"), str("b")), "ERROR: unknown operator: STRING - STRING"},
+		{"identity", Infix("!=", TRUE, TRUE), "false"},
+		{"type_mismatch", Infix("+", one, TRUE), "ERROR: type mismatch: INTEGER + BOOLEAN"},
+		{"unknown", Infix("+", TRUE, FALSE), "ERROR: unknown operator: BOOLEAN + BOOLEAN"},
+		{"bang", Prefix("!", NULL), "true"},
+		{"minus", Prefix("-", two), "-2"},
+		{"minus_overflow", Prefix("-", &Integer{Value: math.MinInt64}), "ERROR: integer overflow: -(-9223372036854775808)"},
+		{"minus_string", Prefix("-", str("a")), "ERROR: unknown operator: -STRING"},
+		{"array_index", Index(arr, one), "2"},
+		{"array_out_of_range", Index(arr, two), "ERROR: index out of range: 2 with length 2"},
+		{"hash_index", Index(hash, str("a")), "1"},
+		{"hash_missing", Index(hash, str("b")), "null"},
+		{"hash_unusable", Index(hash, arr), "ERROR: unusable as hash key: ARRAY"},
+		{"not_indexable", Index(one, one), "ERROR: index operator not supported: INTEGER[INTEGER]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.result.Inspect(); got != tt.expected {
+			t.Errorf("%s: wrong result. expected=%q, got=%q", tt.name, tt.expected, got)
+		}
+	}
+}
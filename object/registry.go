@@ -0,0 +1,62 @@
+package object
+
+import (
+	"maps"
+	"slices"
+)
+
+// Registry is a set of builtin functions, looked up by name. Each
+// evaluator has its own registry, so hosts can add, remove or override
+// builtins for one sandbox without affecting others.
+type Registry struct {
+	builtins map[string]*Builtin
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{builtins: make(map[string]*Builtin)}
+}
+
+// DefaultRegistry returns a new registry holding the standard builtins:
+// len and puts.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, b := range StandardBuiltins() {
+		r.Add(b)
+	}
+	return r
+}
+
+// Register adds a builtin implemented by fn, which expects arity
+// arguments (or NoArguments or Variadic), replacing any builtin of the
+// same name.
+func (r *Registry) Register(name string, arity int, doc string, fn BuiltinFunction) *Builtin {
+	b := &Builtin{Name: name, Arity: arity, Doc: doc, Fn: fn}
+	r.Add(b)
+	return b
+}
+
+// Add adds b under its name, replacing any builtin of the same name.
+func (r *Registry) Add(b *Builtin) {
+	r.builtins[b.Name] = b
+}
+
+// Remove removes the builtin called name, if any.
+func (r *Registry) Remove(name string) {
+	delete(r.builtins, name)
+}
+
+// Lookup returns the builtin called name, or nil if there is none.
+func (r *Registry) Lookup(name string) *Builtin {
+	return r.builtins[name]
+}
+
+// Names returns the names of the builtins in sorted order.
+func (r *Registry) Names() []string {
+	return slices.Sorted(maps.Keys(r.builtins))
+}
+
+// Clone returns a copy of r that can be modified independently.
+func (r *Registry) Clone() *Registry {
+	return &Registry{builtins: maps.Clone(r.builtins)}
+}
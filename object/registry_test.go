@@ -0,0 +1,66 @@
+package object
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"unique"
+)
+
+func TestRegistry(t *testing.T) {
+	r := DefaultRegistry()
+	if names := r.Names(); !slices.Equal(names, []string{"len", "puts"}) {
+		t.Fatalf("wrong default builtins. got=%v", names)
+	}
+
+	clone := r.Clone()
+	clone.Remove("puts")
+	first := clone.Register("first", 1, "first(array) returns the first element of array.", func(args ...Object) Object {
+		return args[0].(*Array).Elements[0]
+	})
+
+	if r.Lookup("puts") == nil || r.Lookup("first") != nil {
+		t.Errorf("modifying a clone changed the original registry: %v", r.Names())
+	}
+	if clone.Lookup("puts") != nil || clone.Lookup("first") != first {
+		t.Errorf("wrong builtins in the clone: %v", clone.Names())
+	}
+	if first.Name != "first" || first.Arity != 1 || first.Doc == "" {
+		t.Errorf("wrong registered builtin: %+v", first)
+	}
+}
+
+func TestDefaultRegistryCopies(t *testing.T) {
+	r := DefaultRegistry()
+	r.Lookup("len").Fn = func(args ...Object) Object { return NULL }
+
+	if got := DefaultRegistry().Lookup("len").Call(&String{Value: unique.Make("ab")}); got.Inspect() != "2" {
+		t.Errorf("modifying a builtin changed another registry. len(\"ab\")=%s", got.Inspect())
+	}
+	if got := StandardBuiltins()[0].Call(&Array{}); got.Inspect() != "0" {
+		t.Errorf("modifying a builtin changed the standard builtins. len([])=%s", got.Inspect())
+	}
+}
+
+func TestBuiltinArity(t *testing.T) {
+	one := &Integer{Value: 1}
+	tests := []struct {
+		builtin  *Builtin
+		args     []Object
+		expected string
+	}{
+		{DefaultRegistry().Lookup("len"), nil, "ERROR: wrong number of arguments. expected 1 but got 0"},
+		{DefaultRegistry().Lookup("len"), []Object{&Array{}}, "0"},
+		{DefaultRegistry().Lookup("len"), []Object{&Array{}, one}, "ERROR: wrong number of arguments. expected 1 but got 2"},
+		{Puts(io.Discard), []Object{one, one, one}, "null"},
+		{&Builtin{Name: "unchecked", Fn: func(args ...Object) Object { return args[1] }}, []Object{one, TRUE}, "true"},
+		{&Builtin{Name: "none", Arity: NoArguments, Fn: func(...Object) Object { return nil }}, nil, "null"},
+		{&Builtin{Name: "none", Arity: NoArguments, Fn: func(...Object) Object { return nil }}, []Object{one}, "ERROR: wrong number of arguments. expected 0 but got 1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.builtin.Call(tt.args...).Inspect(); got != tt.expected {
+			t.Errorf("%s: wrong result. expected=%q, got=%q", tt.builtin.Name, tt.expected, got)
+		}
+	}
+}
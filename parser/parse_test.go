@@ -2,10 +2,15 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/diag"
 	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -225,6 +230,8 @@ func TestParsingInfixExpression(t *testing.T) {
 		{"5 != 5;", 5, "!=", 5},
 		{"true == true;", true, "==", true},
 		{"true != false;", true, "!=", false},
+		{"true && false;", true, "&&", false},
+		{"a || b;", "a", "||", "b"},
 		{"false == false;", false, "==", false},
 	}
 
@@ -272,6 +279,11 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"a + add(b * c) + d", "((a + add((b * c))) + d)"},
 		{"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))", "add(a, b, 1, (2 * 3), (4 + 5), add(6, (7 * 8)))"},
 		{"add(a + b + c * d / f + g)", "add((((a + b) + ((c * d) / f)) + g))"},
+		{"a || b && c", "(a || (b && c))"},
+		{"a && b || c && d", "((a && b) || (c && d))"},
+		{"a == b && !c || d < e", "(((a == b) && (!c)) || (d < e))"},
+		{"a * [1, 2, 3, 4][b * c] * d", "((a * ([1, 2, 3, 4][(b * c)])) * d)"},
+		{"add(a * b[2], b[1], 2 * [1, 2][1])", "add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))"},
 	}
 
 	for _, tt := range tests {
@@ -284,6 +296,79 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestWhileStatement(t *testing.T) {
+	input := "while (x < 10) { x = x + 1; continue; break }"
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", stmt, program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 3 {
+		t.Fatalf("body does not contain 3 statements. got=%d", len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[1] is not *ast.ContinueStatement. got=%T", stmt.Body.Statements[1])
+	}
+	if _, ok := stmt.Body.Statements[2].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[2] is not *ast.BreakStatement. got=%T", stmt.Body.Statements[2])
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	input := "for (x in [1, 2]) { puts(x) }"
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt, ok := program.Statements[0].(*ast.ForInStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", stmt, program.Statements[0])
+	}
+
+	testIdentifier(t, stmt.Variable, "x")
+
+	if _, ok := stmt.Iterable.(*ast.ArrayLiteral); !ok {
+		t.Errorf("stmt.Iterable is not *ast.ArrayLiteral. got=%T", stmt.Iterable)
+	}
+
+	if len(stmt.Body.Statements) != 1 {
+		t.Errorf("body does not contain 1 statements. got=%d", len(stmt.Body.Statements))
+	}
+}
+
+func TestBranchOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break is not in a loop"},
+		{"if (true) { continue }", "1:13: continue is not in a loop"},
+		{"while (true) { fn() { break } }", "1:23: break is not in a loop"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) != 1 {
+			t.Errorf("%q: expected 1 parser error. got=%q", tt.input, errs)
+			continue
+		}
+		if errs[0] != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expected, errs[0])
+		}
+	}
+}
+
 func TestIfExpression(t *testing.T) {
 	input := "if (x < y) { x }"
 
@@ -404,6 +489,49 @@ func TestFunctionExpression(t *testing.T) {
 	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
 }
 
+func TestFunctionStatement(t *testing.T) {
+	input := "fn add(x, y) { x + y; }; fn(z) { z }"
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 2)
+
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not %T. got=%T", stmt, program.Statements[0])
+	}
+	testIdentifier(t, stmt.Name, "add")
+	if stmt.Function.Name != "add" {
+		t.Errorf("function name wrong. want=%q, got=%q", "add", stmt.Function.Name)
+	}
+	if len(stmt.Function.Parameters) != 2 {
+		t.Fatalf("function parameters wrong. want 2, got=%d", len(stmt.Function.Parameters))
+	}
+	if got := stmt.String(); got != "fn add(x, y)(x + y)" {
+		t.Errorf("stmt.String() wrong. got=%q", got)
+	}
+
+	// a function literal without a name is still an expression
+	es, ok := program.Statements[1].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not %T. got=%T", es, program.Statements[1])
+	}
+	if fl, ok := es.Expression.(*ast.FunctionLiteral); !ok || fl.Name != "" {
+		t.Errorf("program.Statements[1] is not an anonymous function. got=%s", es.Expression)
+	}
+}
+
+func TestLetFunctionName(t *testing.T) {
+	program := testParse(t, "let f = fn() { 1 }; let g = f;")
+
+	fl, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("let value is not %T. got=%T", fl, program.Statements[0].(*ast.LetStatement).Value)
+	}
+	if fl.Name != "f" {
+		t.Errorf("function name wrong. want=%q, got=%q", "f", fl.Name)
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := `add(3*8, 1)`
 
@@ -432,6 +560,106 @@ func TestCallExpression(t *testing.T) {
 	testLiteralExpression(t, call.Arguments[1], 1)
 }
 
+func TestParsingArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp is not %T. got=%T", array, stmt.Expression)
+	}
+
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) not 3. got=%d", len(array.Elements))
+	}
+
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestParsingEmptyArrayLiteral(t *testing.T) {
+	program := testParse(t, "[]")
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp is not %T. got=%T", array, stmt.Expression)
+	}
+
+	if len(array.Elements) != 0 {
+		t.Errorf("len(array.Elements) not 0. got=%d", len(array.Elements))
+	}
+}
+
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp is not %T. got=%T", indexExp, stmt.Expression)
+	}
+
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+
+	testInfixExpression(t, indexExp.Index, 1, "+", 1)
+}
+
+func TestParsingHashLiterals(t *testing.T) {
+	input := `{"one": 1, 2: "two", true: 3 + 4}`
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not %T. got=%T", hash, stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 3 {
+		t.Fatalf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+
+	key, ok := hash.Pairs[0].Key.(*ast.StringLiteral)
+	if !ok || key.Value != "one" {
+		t.Errorf("hash.Pairs[0].Key is not \"one\". got=%s", hash.Pairs[0].Key)
+	}
+	testIntegerLiteral(t, hash.Pairs[0].Value, 1)
+	testIntegerLiteral(t, hash.Pairs[1].Key, 2)
+	testBooleanLiteral(t, hash.Pairs[2].Key, true)
+	testInfixExpression(t, hash.Pairs[2].Value, 3, "+", 4)
+
+	if hash.String() != "{one: 1, 2: two, true: (3 + 4)}" {
+		t.Errorf("hash.String() wrong. got=%q", hash.String())
+	}
+}
+
+func TestParsingEmptyHashLiteral(t *testing.T) {
+	program := testParse(t, "{}")
+	checkProgramStatementsLength(t, program, 1)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	hash, ok := stmt.Expression.(*ast.HashLiteral)
+	if !ok {
+		t.Fatalf("exp is not %T. got=%T", hash, stmt.Expression)
+	}
+
+	if len(hash.Pairs) != 0 {
+		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
+	}
+}
+
 func testInfixExpression(t *testing.T, exp ast.Expression, left any, operator string, right any) bool {
 	opExp, ok := exp.(*ast.InfixExpression)
 	if !ok {
@@ -552,3 +780,256 @@ func testParse(t *testing.T, input string) *ast.Program {
 	checkParserErrors(t, p)
 	return program
 }
+
+func TestComments(t *testing.T) {
+	input := `// add two numbers
+let add = fn(x, y) {
+    x + y; /* sum */
+};
+add(1, 2) // call`
+
+	l := lexer.New(input)
+	l.SetMode(lexer.ScanComments)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	checkProgramStatementsLength(t, program, 2)
+
+	expected := []string{"// add two numbers", "/* sum */", "// call"}
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("program.Comments has wrong length. want %d, got=%d", len(expected), len(program.Comments))
+	}
+	for i, c := range program.Comments {
+		if c.Literal != expected[i] {
+			t.Errorf("program.Comments[%d] wrong. expected=%q, got=%q", i, expected[i], c.Literal)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"add(1,\n  2", "2:4: expected next token to be ), got EOF instead"},
+		{"1 +\n\n  ;", "3:3: no prefix parse function for ; found"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) == 0 {
+			t.Errorf("%q: expected parser errors", tt.input)
+			continue
+		}
+		if errs[0] != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expected, errs[0])
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		code  diag.Code
+		msg   string
+		fix   *diag.Fix
+	}{
+		{"let x 5;", diag.UnexpectedToken, "1:7: expected next token to be =, got INT instead",
+			&diag.Fix{Message: `insert "="`, Pos: pos(5, 1, 6), End: pos(5, 1, 6), NewText: "="}},
+		{"add(1,\n  2", diag.UnexpectedToken, "2:4: expected next token to be ), got EOF instead",
+			&diag.Fix{Message: `insert ")"`, Pos: pos(10, 2, 4), End: pos(10, 2, 4), NewText: ")"}},
+		{"while (x) { y", diag.UnexpectedToken, "1:14: expected }, got EOF instead",
+			&diag.Fix{Message: `insert "}"`, Pos: pos(13, 1, 14), End: pos(13, 1, 14), NewText: "}"}},
+		{"let = 5;", diag.UnexpectedToken, "1:5: expected next token to be IDENT, got = instead", nil},
+		{"99999999999999999999", diag.InvalidInteger, `1:1: could not parse "99999999999999999999" as integer`, nil},
+		{"break;", diag.BranchOutside, "1:1: break is not in a loop", nil},
+		// reported by the lexer only
+		{"1 @ 2", diag.IllegalCharacter, `1:3: illegal character "@"`, nil},
+		{"let x = a & b;", diag.IllegalCharacter, `1:11: illegal character "&"`,
+			&diag.Fix{Message: `replace with "&&"`, Pos: pos(10, 1, 11), End: pos(11, 1, 12), NewText: "&&"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		diags := p.Diagnostics()
+		if len(diags) != 1 {
+			t.Errorf("%q: expected 1 diagnostic, got=%v", tt.input, diags)
+			continue
+		}
+		d := diags[0]
+		if d.Code != tt.code || d.Error() != tt.msg {
+			t.Errorf("%q: wrong diagnostic. expected=%s %q, got=%s %q", tt.input, tt.code, tt.msg, d.Code, d.Error())
+		}
+		if !reflect.DeepEqual(d.Fix, tt.fix) {
+			t.Errorf("%q: wrong fix. expected=%+v, got=%+v", tt.input, tt.fix, d.Fix)
+		}
+	}
+}
+
+func pos(offset, line, column int) token.Position {
+	return token.Position{Offset: offset, Line: line, Column: column}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		errors   []string
+		expected string
+	}{
+		{
+			"let = 1;\nlet x 2;\nlet y = 3;",
+			[]string{"1:5: expected next token to be IDENT, got = instead", "2:7: expected next token to be =, got INT instead"},
+			"let y = 3;",
+		},
+		{
+			"let x = 1 +\nlet y = 2;",
+			[]string{"2:1: no prefix parse function for LET found"},
+			"let y = 2;",
+		},
+		{
+			"let f = fn() { let x = ; 1 }; f()",
+			[]string{"1:24: no prefix parse function for ; found"},
+			"let f = ()1;f()",
+		},
+		{
+			"while (true) { let x = }\nx",
+			[]string{"1:24: no prefix parse function for } found"},
+			"while true x",
+		},
+		{
+			"if (x { 1 }; 2",
+			[]string{"1:7: expected next token to be ), got { instead"},
+			"2",
+		},
+		{
+			`let h = {"a" 1, "b": {}}; h`,
+			[]string{"1:14: expected next token to be :, got INT instead"},
+			"h",
+		},
+		{
+			"add(1, fn(1) { 2 }); 3",
+			[]string{"1:11: expected next token to be IDENT, got INT instead"},
+			"3",
+		},
+		{
+			"} 1; }",
+			[]string{"1:1: no prefix parse function for } found", "1:6: no prefix parse function for } found"},
+			"",
+		},
+		{
+			"fn f() { 1",
+			[]string{"1:11: expected }, got EOF instead"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if errs := p.Errors(); !slices.Equal(errs, tt.errors) {
+			t.Errorf("%q: wrong errors.\nexpected=%q\ngot=     %q", tt.input, tt.errors, errs)
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong program. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestNestingLimit(t *testing.T) {
+	deep := 3_000_000
+	tests := []struct {
+		name  string
+		input string
+		msg   string
+	}{
+		{"arrays", strings.Repeat("[", deep), "1:10001: nesting too deep: more than 10000 levels"},
+		{"minus", strings.Repeat("-", deep) + "1", "1:10001: nesting too deep: more than 10000 levels"},
+		{"infix", "1" + strings.Repeat(" + 1", deep), "1:39997: nesting too deep: more than 10000 levels"},
+		{"calls", "f" + strings.Repeat("()", deep), "1:19999: nesting too deep: more than 10000 levels"},
+		{"blocks", strings.Repeat("while (true) {", deep), "1:140008: nesting too deep: more than 10000 levels"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		diags := p.Diagnostics()
+		if len(diags) != 1 {
+			t.Errorf("%s: expected 1 diagnostic, got=%d", tt.name, len(diags))
+			continue
+		}
+		if d := diags[0]; d.Code != diag.NestingTooDeep || d.Error() != tt.msg {
+			t.Errorf("%s: wrong diagnostic. expected=%q, got=%s %q", tt.name, tt.msg, d.Code, d.Error())
+		}
+	}
+
+	input := strings.Repeat("[", 5000) + strings.Repeat("]", 5000)
+	p := New(lexer.New(input))
+	if program := p.ParseProgram(); len(p.Errors()) != 0 || program.String() != input {
+		t.Errorf("nesting below the limit not parsed. errors=%v", p.Errors())
+	}
+}
+
+// FuzzParser checks that the parser does not panic and that it reports
+// an error for each statement it drops.
+func FuzzParser(f *testing.F) {
+	for _, seed := range []string{
+		"let x = 5; let y = fn(a, b) { a + b }; y(x, 2)",
+		"if (x < y) { x } else { y }",
+		`let h = {"a": [1, 2], true: !false}; h["a"][0]`,
+		"while (i < 10) { i = i + 1; if (i == 5) { break } }",
+		"for (x in [1, 2]) { continue; }",
+		"fn f(n) { return f(n - 1) } // comment",
+		"let = ; } ) { fn(1) [,]",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		_ = program.String()
+
+		for _, stmt := range program.Statements {
+			if stmt == nil {
+				t.Fatalf("nil statement in %q", input)
+			}
+		}
+	})
+}
+
+func TestNodePositions(t *testing.T) {
+	input := "let a = add(1, 2);\nif (a) { a } else { -a }"
+
+	program := testParse(t, input)
+	checkProgramStatementsLength(t, program, 2)
+
+	tests := []struct {
+		node  ast.Node
+		pos   string
+		end   string
+		label string
+	}{
+		{program.Statements[0], "1:1", "1:18", "let"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:9", "1:18", "call"},
+		{program.Statements[1], "2:1", "2:25", "if"},
+		{program, "1:1", "2:25", "program"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.pos {
+			t.Errorf("%s: Pos wrong. expected=%s, got=%s", tt.label, tt.pos, got)
+		}
+		if got := tt.node.End().String(); got != tt.end {
+			t.Errorf("%s: End wrong. expected=%s, got=%s", tt.label, tt.end, got)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package parser
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 
 	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/diag"
 	"github.com/pirosiki197/monkey/lexer"
 	"github.com/pirosiki197/monkey/token"
 )
@@ -17,15 +19,25 @@ type (
 const (
 	_ int = iota
 	LOWEST
+	LOGICAL_OR
+	LOGICAL_AND
 	EQUALS
 	LESSGREATER
 	SUM
 	PRODUCT
 	PREFIX
 	CALL
+	INDEX
 )
 
+// maxNesting is the deepest expressions and blocks may nest. Deeper
+// input is reported instead of overflowing the stack of the parser or of
+// the passes walking its result.
+const maxNesting = 10000
+
 var precedences = map[token.TokenType]int{
+	token.OR:       LOGICAL_OR,
+	token.AND:      LOGICAL_AND,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -37,6 +49,7 @@ var precedences = map[token.TokenType]int{
 	token.ASTERISK: PRODUCT,
 	token.SLASH:    PRODUCT,
 	token.LPAREN:   CALL,
+	token.LBRACKET: INDEX,
 }
 
 type Parser struct {
@@ -48,7 +61,16 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
-	errs []string
+	comments []token.Token
+
+	// number of loops enclosing the current token within the current function
+	loopDepth int
+	// number of unclosed braces before the current token
+	braces int
+	// number of expressions and blocks enclosing the current token
+	nesting int
+
+	diags []*diag.Diagnostic
 }
 
 func New(l *lexer.Lexer) *Parser {
@@ -66,6 +88,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefixFn(token.LPAREN, p.parseGroupExpression)
 	p.registerPrefixFn(token.IF, p.parseIfExpression)
 	p.registerPrefixFn(token.FUNCTION, p.parseFunctionExpression)
+	p.registerPrefixFn(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefixFn(token.LBRACE, p.parseHashLiteral)
 	p.registerPrefixFn(token.BANG, p.parsePrefixExpression)
 	p.registerPrefixFn(token.MINUS, p.parsePrefixExpression)
 
@@ -79,7 +103,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfixFn(token.GT, p.parseInfixExpression)
 	p.registerInfixFn(token.LT_EQ, p.parseInfixExpression)
 	p.registerInfixFn(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfixFn(token.AND, p.parseInfixExpression)
+	p.registerInfixFn(token.OR, p.parseInfixExpression)
 	p.registerInfixFn(token.LPAREN, p.parseFunctionCallExpression)
+	p.registerInfixFn(token.LBRACKET, p.parseIndexExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -95,8 +122,18 @@ func (p *Parser) registerInfixFn(t token.TokenType, fn infixParseFn) {
 }
 
 func (p *Parser) nextToken() {
+	switch {
+	case p.curTokenIs(token.LBRACE):
+		p.braces++
+	case p.curTokenIs(token.RBRACE) && p.braces > 0:
+		p.braces--
+	}
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekTokenIs(token.COMMENT) {
+		p.comments = append(p.comments, p.peekToken)
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -107,25 +144,93 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 	return p.peekToken.Type == t
 }
 
+// bailout is panicked with to abandon parsing after an error the parser
+// cannot recover from.
+type bailout struct{}
+
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			program.Comments = p.comments
+		}
+	}()
 
 	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		program.Statements = append(program.Statements, stmt)
-		p.nextToken()
+		if stmt := p.parseStatement(); stmt != nil {
+			program.Statements = append(program.Statements, stmt)
+			p.nextToken()
+		}
 	}
+	program.Comments = p.comments
 
 	return program
 }
 
+// parseStatement parses the statement starting at the current token and
+// leaves the parser at its last token. A statement with a syntax error is
+// skipped: parseStatement returns nil and advances to the start of the
+// next statement.
 func (p *Parser) parseStatement() ast.Statement {
+	start, braces := p.curToken, p.braces
+	stmt := p.parseStatementKind()
+	if stmt == nil {
+		p.synchronize(start, braces)
+	}
+	return stmt
+}
+
+// synchronize skips the rest of a statement with a syntax error. The bad
+// statement began at start, with braces unclosed braces before it. The
+// next statement starts after a semicolon or at a statement keyword
+// outside of the braces opened by the bad statement; synchronize also
+// stops at the brace closing the enclosing block. Nothing is reported
+// until then, so each mistake gives a single error.
+func (p *Parser) synchronize(start token.Token, braces int) {
+	if p.curToken.Pos == start.Pos {
+		p.nextToken()
+	}
+	for !p.curTokenIs(token.EOF) {
+		if p.braces == braces {
+			switch p.curToken.Type {
+			case token.SEMICOLON:
+				p.nextToken()
+				return
+			case token.RBRACE:
+				if braces > 0 {
+					return
+				}
+			case token.LET, token.RETURN, token.WHILE, token.FOR:
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// parseStatementKind parses a statement of the kind its first token
+// starts. It returns nil after reporting a syntax error.
+func (p *Parser) parseStatementKind() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForInStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseBranchStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
 			return p.parseAssignStatement()
@@ -134,7 +239,7 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
 	if !p.expectPeek(token.IDENT) {
@@ -150,6 +255,12 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 
 	if p.peekToken.Type == token.SEMICOLON {
 		p.nextToken()
@@ -158,7 +269,28 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
-func (p *Parser) parseAssignStatement() *ast.AssignStatement {
+func (p *Parser) parseFunctionStatement() ast.Statement {
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	fl, ok := p.parseFunctionExpression().(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	fl.Token = stmt.Token
+	fl.Name = stmt.Name.Value
+	stmt.Function = fl
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseAssignStatement() ast.Statement {
 	stmt := &ast.AssignStatement{Token: p.curToken}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
@@ -166,6 +298,9 @@ func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST)
+	if stmt.Value == nil {
+		return nil
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -173,12 +308,15 @@ func (p *Parser) parseAssignStatement() *ast.AssignStatement {
 	return stmt
 }
 
-func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
+func (p *Parser) parseReturnStatement() ast.Statement {
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
 	p.nextToken()
 
 	stmt.ReturnValue = p.parseExpression(LOWEST)
+	if stmt.ReturnValue == nil {
+		return nil
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -187,8 +325,83 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForInStatement() ast.Statement {
+	stmt := &ast.ForInStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if stmt.Iterable == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+	return p.parseBlockStatement()
+}
+
+// parseBranchStatement parses break and continue.
+func (p *Parser) parseBranchStatement() ast.Statement {
+	tok := p.curToken
+	if p.loopDepth == 0 {
+		p.errorf(tok, diag.BranchOutside, "%s is not in a loop", tok.Literal)
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken, Expression: p.parseExpression(LOWEST)}
+	if stmt.Expression == nil {
+		return nil
+	}
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -196,31 +409,49 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	defer p.restoreNesting(p.nesting)
+	p.nest()
 	stmt := &ast.BlockStatement{Token: p.curToken}
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt.Statements = append(stmt.Statements, p.parseStatement())
-		p.nextToken()
+		if s := p.parseStatement(); s != nil {
+			stmt.Statements = append(stmt.Statements, s)
+			p.nextToken()
+		}
+	}
+	if p.curTokenIs(token.EOF) {
+		d := p.errorf(p.curToken, diag.UnexpectedToken, "expected %s, got %s instead", token.RBRACE, token.EOF)
+		d.Fix = &diag.Fix{Message: `insert "}"`, Pos: p.curToken.Pos, End: p.curToken.Pos, NewText: "}"}
+		return nil
 	}
+	stmt.Rbrace = p.curToken.Pos
 	return stmt
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	p.errs = append(p.errs, fmt.Sprintf("no prefix parse function for %s found", t))
+	if t == token.ILLEGAL {
+		// already reported by the lexer
+		return
+	}
+	p.errorf(p.curToken, diag.UnexpectedToken, "no prefix parse function for %s found", t)
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	defer p.restoreNesting(p.nesting)
+	p.nest()
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
 		return nil
 	}
 	leftExp := prefix()
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	for leftExp != nil && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
 		}
+		// the result encloses leftExp
+		p.nest()
 		p.nextToken()
 		leftExp = infix(leftExp)
 	}
@@ -237,7 +468,7 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseInteger() ast.Expression {
 	v, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		p.errs = append(p.errs, fmt.Sprintf("could not parse %q as integer", p.curToken.Literal))
+		p.errorf(p.curToken, diag.InvalidInteger, "could not parse %q as integer", p.curToken.Literal)
 		return nil
 	}
 	return &ast.IntegerLiteral{
@@ -247,7 +478,6 @@ func (p *Parser) parseInteger() ast.Expression {
 }
 
 func (p *Parser) parseString() ast.Expression {
-	// TODO: parse escaped characters? (e.g. \n, \")
 	return &ast.StringLiteral{
 		Token: p.curToken,
 		Value: p.curToken.Literal,
@@ -265,7 +495,7 @@ func (p *Parser) parseBoolean() ast.Expression {
 func (p *Parser) parseGroupExpression() ast.Expression {
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
-	if !p.expectPeek(token.RPAREN) {
+	if exp == nil || !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 	return exp
@@ -278,6 +508,9 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	}
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
@@ -290,6 +523,9 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	precedence := p.curPrecedence()
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
@@ -301,13 +537,16 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
-	if !p.expectPeek(token.RPAREN) {
+	if expression.Condition == nil || !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 	expression.Consequence = p.parseBlockStatement()
+	if expression.Consequence == nil {
+		return nil
+	}
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
@@ -315,6 +554,9 @@ func (p *Parser) parseIfExpression() ast.Expression {
 			return nil
 		}
 		expression.Alternative = p.parseBlockStatement()
+		if expression.Alternative == nil {
+			return nil
+		}
 	}
 
 	return expression
@@ -328,33 +570,41 @@ func (p *Parser) parseFunctionExpression() ast.Expression {
 	}
 
 	expression.Parameters = p.parseFunctionParameters()
-
-	if !p.expectPeek(token.LBRACE) {
+	if expression.Parameters == nil || !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	// break and continue cannot cross a function boundary
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	expression.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
+	if expression.Body == nil {
+		return nil
+	}
 
 	return expression
 }
 
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	p.nextToken()
-
 	params := make([]*ast.Identifier, 0)
 
-	if p.curTokenIs(token.RPAREN) {
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
 		return params
 	}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	params = append(params, ident)
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		params = append(params, ident)
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
 	}
 
 	if !p.expectPeek(token.RPAREN) {
@@ -369,33 +619,97 @@ func (p *Parser) parseFunctionCallExpression(call ast.Expression) ast.Expression
 		Token:    p.curToken,
 		Function: call,
 	}
-	expression.Arguments = p.parseCallArguments()
+	expression.Arguments = p.parseExpressionList(token.RPAREN)
+	if expression.Arguments == nil {
+		return nil
+	}
+	expression.Rparen = p.curToken.Pos
 	return expression
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	p.nextToken()
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return nil
+	}
+	array.Rbrack = p.curToken.Pos
+	return array
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken, Pairs: []ast.HashPair{}}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+		if key == nil || !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
 
-	args := make([]ast.Expression, 0)
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
 
-	// no arguments
-	if p.curToken.Type == token.RPAREN {
-		return args
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
 	}
 
-	args = append(args, p.parseExpression(LOWEST))
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hash.Rbrace = p.curToken.Pos
 
-	for p.peekTokenIs(token.COMMA) {
+	return hash
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	expression := &ast.IndexExpression{Token: p.curToken, Left: left}
+
+	p.nextToken()
+	expression.Index = p.parseExpression(LOWEST)
+	if expression.Index == nil || !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	expression.Rbrack = p.curToken.Pos
+
+	return expression
+}
+
+// parseExpressionList parses comma-separated expressions up to the end token.
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	p.nextToken()
+
+	list := make([]ast.Expression, 0)
+
+	// empty list
+	if p.curTokenIs(end) {
+		return list
+	}
+
+	for {
+		exp := p.parseExpression(LOWEST)
+		if exp == nil {
+			return nil
+		}
+		list = append(list, exp)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(end) {
 		return nil
 	}
 
-	return args
+	return list
 }
 
 func (p *Parser) expectPeek(t token.TokenType) bool {
@@ -408,6 +722,20 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
+// nest enters another level of nesting. It reports an error and abandons
+// parsing if there are too many levels.
+func (p *Parser) nest() {
+	p.nesting++
+	if p.nesting > maxNesting {
+		p.errorf(p.curToken, diag.NestingTooDeep, "nesting too deep: more than %d levels", maxNesting)
+		panic(bailout{})
+	}
+}
+
+func (p *Parser) restoreNesting(nesting int) {
+	p.nesting = nesting
+}
+
 func (p *Parser) curPrecedence() int {
 	if p, ok := precedences[p.curToken.Type]; ok {
 		return p
@@ -423,10 +751,50 @@ func (p *Parser) peekPrecedence() int {
 }
 
 func (p *Parser) peekError(tok token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", tok, p.peekToken.Type)
-	p.errs = append(p.errs, msg)
+	if p.peekTokenIs(token.ILLEGAL) {
+		// already reported by the lexer
+		return
+	}
+	d := p.errorf(p.peekToken, diag.UnexpectedToken, "expected next token to be %s, got %s instead", tok, p.peekToken.Type)
+	switch tok {
+	case token.ASSIGN, token.COLON, token.RPAREN, token.RBRACKET, token.RBRACE:
+		// the token is probably missing rather than misspelled
+		text := tok.String()
+		d.Fix = &diag.Fix{Message: fmt.Sprintf("insert %q", text), Pos: p.curToken.End, End: p.curToken.End, NewText: text}
+	}
+}
+
+// errorf records a syntax error at tok.
+func (p *Parser) errorf(tok token.Token, code diag.Code, format string, a ...any) *diag.Diagnostic {
+	d := &diag.Diagnostic{
+		Severity: diag.Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+	}
+	p.diags = append(p.diags, d)
+	return d
+}
+
+// Diagnostics returns the problems found by the lexer and the parser,
+// sorted by position.
+func (p *Parser) Diagnostics() []*diag.Diagnostic {
+	diags := slices.Concat(p.l.Diagnostics(), p.diags)
+	slices.SortStableFunc(diags, func(a, b *diag.Diagnostic) int {
+		return a.Pos.Offset - b.Pos.Offset
+	})
+	return diags
 }
 
+// Errors returns the messages of the errors among the diagnostics,
+// prefixed with their source position.
 func (p *Parser) Errors() []string {
-	return p.errs
+	var msgs []string
+	for _, d := range p.Diagnostics() {
+		if d.Severity == diag.Error {
+			msgs = append(msgs, d.Error())
+		}
+	}
+	return msgs
 }
@@ -4,11 +4,13 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"os"
 
+	"github.com/pirosiki197/monkey/diag"
 	"github.com/pirosiki197/monkey/evaluator"
 	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/object"
 	"github.com/pirosiki197/monkey/parser"
+	"github.com/pirosiki197/monkey/resolver"
 )
 
 const PROMPT = ">> "
@@ -16,7 +18,13 @@ const PROMPT = ">> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 
-	evaluator := evaluator.New()
+	env := object.NewEnvironment()
+	builtins := object.DefaultRegistry()
+	evaluator := evaluator.NewWithEnv(env, evaluator.WithBuiltins(builtins), evaluator.WithResolvedPrograms())
+	isGlobal := func(name string) bool {
+		_, ok := env.Get(name)
+		return ok || builtins.Lookup(name) != nil
+	}
 	for {
 		fmt.Print(PROMPT)
 		if !scanner.Scan() {
@@ -27,8 +35,19 @@ func Start(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 
 		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			printParseErrors(os.Stdout, p.Errors())
+		diags := p.Diagnostics()
+		if len(diags) == 0 {
+			for _, err := range resolver.Resolve(program, isGlobal) {
+				// a function may be called after a later line binds the name
+				if !err.Late {
+					diags = append(diags, err.Diagnostic())
+				}
+			}
+		}
+		if len(diags) != 0 {
+			if err := diag.RenderAll(out, diags, line); err != nil {
+				return
+			}
 			continue
 		}
 
@@ -39,9 +58,3 @@ func Start(in io.Reader, out io.Writer) {
 		}
 	}
 }
-
-func printParseErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
-	}
-}
@@ -0,0 +1,266 @@
+// Package resolver binds identifiers to the variables they refer to before
+// a program is evaluated.
+//
+// Every block declaring variables, every function call and every
+// iteration of a for-in loop gets an environment with one slot per
+// variable. The resolver records on each local ast.Identifier how many
+// environments up its variable lives and in which slot, so that the
+// evaluator can look it up by index. Variables declared at the top level
+// of a program are globals and stay looked up by name.
+//
+// Names declared by let are visible from the start of their block in
+// nested functions, which allows functions to call each other, but only
+// after the let statement in the code of the block itself. Functions
+// declared with fn name(...) are bound when their block is entered and
+// are visible throughout it.
+//
+// Resolve stores its results in the AST, so a program must not be
+// resolved while it is being evaluated.
+package resolver
+
+import (
+	"fmt"
+
+	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/diag"
+	"github.com/pirosiki197/monkey/token"
+)
+
+// Error reports an identifier that does not refer to any variable.
+type Error struct {
+	Pos  token.Position
+	End  token.Position
+	Name string
+	// Late is set for an identifier inside a function. Globals are looked
+	// up by name when the code runs, so the name may still be bound
+	// before the function is called, for example by a later line of the
+	// REPL.
+	Late bool
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: identifier not found: %s", e.Pos, e.Name)
+}
+
+// Diagnostic returns e as a diagnostic.
+func (e *Error) Diagnostic() *diag.Diagnostic {
+	return &diag.Diagnostic{
+		Severity: diag.Error,
+		Code:     diag.UndefinedIdentifier,
+		Message:  "identifier not found: " + e.Name,
+		Pos:      e.Pos,
+		End:      e.End,
+	}
+}
+
+type binding struct {
+	slot    int
+	defined bool
+}
+
+type scope struct {
+	names map[string]*binding
+	// function is set for the scope holding the parameters of a function.
+	function bool
+}
+
+func (s *scope) declare(name string) *binding {
+	if b, ok := s.names[name]; ok {
+		return b
+	}
+	b := &binding{slot: len(s.names)}
+	s.names[name] = b
+	return b
+}
+
+type resolver struct {
+	scopes   []*scope // innermost last
+	globals  map[string]bool
+	isGlobal func(name string) bool
+	errs     []*Error
+}
+
+// Resolve resolves the identifiers in program. isGlobal reports whether a
+// name is bound outside of the program, in the global environment or as
+// a builtin. The returned errors list the unresolved identifiers in
+// source order.
+func Resolve(program *ast.Program, isGlobal func(name string) bool) []*Error {
+	r := &resolver{globals: make(map[string]bool), isGlobal: isGlobal}
+
+	for _, stmt := range program.Statements {
+		if name := declaredName(stmt); name != nil {
+			r.globals[name.Value] = true
+		}
+	}
+	for _, stmt := range program.Statements {
+		r.resolve(stmt)
+	}
+	return r.errs
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+
+	case *ast.LetStatement:
+		r.resolve(node.Value)
+		r.define(node.Name)
+
+	case *ast.FunctionStatement:
+		r.define(node.Name)
+		r.resolve(node.Function)
+
+	case *ast.AssignStatement:
+		r.resolve(node.Value)
+		r.lookup(node.Name)
+
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+
+	case *ast.BlockStatement:
+		r.resolveBlock(node)
+
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.resolveBlock(node.Body)
+
+	case *ast.ForInStatement:
+		r.resolve(node.Iterable)
+		r.push(false)
+		r.define(node.Variable)
+		r.resolveBlock(node.Body)
+		r.pop()
+
+	case *ast.Identifier:
+		r.lookup(node)
+
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolveBlock(node.Consequence)
+		if node.Alternative != nil {
+			r.resolveBlock(node.Alternative)
+		}
+
+	case *ast.FunctionLiteral:
+		r.push(true)
+		for _, p := range node.Parameters {
+			r.define(p)
+		}
+		r.resolveBlock(node.Body)
+		r.pop()
+
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, a := range node.Arguments {
+			r.resolve(a)
+		}
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.resolve(el)
+		}
+
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			r.resolve(pair.Key)
+			r.resolve(pair.Value)
+		}
+	}
+}
+
+// resolveBlock resolves a block, which gets a scope of its own if it
+// declares any variables.
+func (r *resolver) resolveBlock(block *ast.BlockStatement) {
+	var s *scope
+	for _, stmt := range block.Statements {
+		name := declaredName(stmt)
+		if name == nil {
+			continue
+		}
+		if s == nil {
+			s = r.push(false)
+		}
+		b := s.declare(name.Value)
+		if _, ok := stmt.(*ast.FunctionStatement); ok {
+			b.defined = true
+		}
+	}
+
+	block.NumSlots = 0
+	if s != nil {
+		block.NumSlots = len(s.names)
+	}
+
+	for _, stmt := range block.Statements {
+		r.resolve(stmt)
+	}
+
+	if s != nil {
+		r.pop()
+	}
+}
+
+// declaredName returns the name a let statement or function declaration
+// binds in its block, or nil for other statements.
+func declaredName(stmt ast.Statement) *ast.Identifier {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		return stmt.Name
+	case *ast.FunctionStatement:
+		return stmt.Name
+	}
+	return nil
+}
+
+func (r *resolver) push(function bool) *scope {
+	s := &scope{names: make(map[string]*binding), function: function}
+	r.scopes = append(r.scopes, s)
+	return s
+}
+
+func (r *resolver) pop() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// define binds ident in the innermost scope, or as a global at the top
+// level, and makes it visible to the code that follows.
+func (r *resolver) define(ident *ast.Identifier) {
+	if len(r.scopes) == 0 {
+		ident.Local = false
+		return
+	}
+	b := r.scopes[len(r.scopes)-1].declare(ident.Value)
+	b.defined = true
+	ident.Local, ident.Depth, ident.Slot = true, 0, b.slot
+}
+
+func (r *resolver) lookup(ident *ast.Identifier) {
+	nested := false
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		s := r.scopes[i]
+		if b, ok := s.names[ident.Value]; ok && (b.defined || nested) {
+			ident.Local, ident.Depth, ident.Slot = true, len(r.scopes)-1-i, b.slot
+			return
+		}
+		if s.function {
+			nested = true
+		}
+	}
+
+	ident.Local = false
+	if !r.globals[ident.Value] && !r.isGlobal(ident.Value) {
+		r.errs = append(r.errs, &Error{Pos: ident.Pos(), End: ident.End(), Name: ident.Value, Late: nested})
+	}
+}
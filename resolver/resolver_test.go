@@ -0,0 +1,149 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pirosiki197/monkey/ast"
+	"github.com/pirosiki197/monkey/diag"
+	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/parser"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string // resolution of each identifier, in source order
+	}{
+		{"global", "let a = 1; a", "a:global a:global"},
+		{"parameter", "fn(a, b) { b }", "a:0.0 b:0.1 b:0.1"},
+		{"block_local", "fn(a) { let b = a; b }", "a:0.0 b:0.0 a:1.0 b:0.0"},
+		{"shadowing", "fn(a) { let a = a; a }", "a:0.0 a:0.0 a:1.0 a:0.0"},
+		{"closure", "fn(a) { fn() { a } }", "a:0.0 a:1.0"},
+		{
+			"mutual_recursion",
+			"fn() { let f = fn() { g() }; let g = fn() { f() }; }",
+			"f:0.0 g:1.1 g:0.1 f:1.0",
+		},
+		{"function_declaration", "fn() { f(); fn f() { f } }", "f:0.0 f:0.0 f:1.0"},
+		{"global_function_declaration", "f(); fn f() { f }", "f:global f:global f:global"},
+		{"for_in", "fn(xs) { for (x in xs) { x } }", "xs:0.0 x:0.0 xs:0.0 x:0.0"},
+		{"block_without_lets", "fn(a) { if (a) { a } }", "a:0.0 a:0.0 a:0.0"},
+		{"builtin", "len", "len:global"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program := parser.New(lexer.New(tt.input)).ParseProgram()
+			if errs := Resolve(program, isBuiltin); len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs)
+			}
+
+			if got := strings.Join(identifiers(program), " "); got != tt.expected {
+				t.Errorf("wrong resolution.\nwant=%s\ngot= %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	input := `
+let f = fn() { missing + 1 };
+if (true) { let local = 1; }
+local = 2;`
+
+	errs := Resolve(parser.New(lexer.New(input)).ParseProgram(), isBuiltin)
+
+	expected := []string{
+		"2:16: identifier not found: missing",
+		"4:1: identifier not found: local",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("wrong number of errors. want=%d, got=%v", len(expected), errs)
+	}
+	for i, want := range expected {
+		if errs[i].Error() != want {
+			t.Errorf("errs[%d] wrong. want=%q, got=%q", i, want, errs[i].Error())
+		}
+		// only the identifier inside the function is bound late
+		if errs[i].Late != (i == 0) {
+			t.Errorf("errs[%d].Late wrong. got=%t", i, errs[i].Late)
+		}
+	}
+
+	d := errs[0].Diagnostic()
+	if d.Code != diag.UndefinedIdentifier || d.Error() != expected[0] {
+		t.Errorf("wrong diagnostic. got=%s %q", d.Code, d.Error())
+	}
+	if d.End.Line != 2 || d.End.Column != 23 {
+		t.Errorf("wrong end of the diagnostic. got=%s", d.End)
+	}
+}
+
+func isBuiltin(name string) bool {
+	return name == "len"
+}
+
+// identifiers lists the resolution of the identifiers of program as
+// name:depth.slot or name:global, in source order.
+func identifiers(program *ast.Program) []string {
+	var out []string
+	ident := func(i *ast.Identifier) {
+		if i.Local {
+			out = append(out, fmt.Sprintf("%s:%d.%d", i.Value, i.Depth, i.Slot))
+		} else {
+			out = append(out, i.Value+":global")
+		}
+	}
+
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				walk(s)
+			}
+		case *ast.LetStatement:
+			ident(node.Name)
+			walk(node.Value)
+		case *ast.AssignStatement:
+			ident(node.Name)
+			walk(node.Value)
+		case *ast.FunctionStatement:
+			ident(node.Name)
+			walk(node.Function)
+		case *ast.ExpressionStatement:
+			walk(node.Expression)
+		case *ast.ForInStatement:
+			ident(node.Variable)
+			walk(node.Iterable)
+			walk(node.Body)
+		case *ast.Identifier:
+			ident(node)
+		case *ast.IfExpression:
+			walk(node.Condition)
+			walk(node.Consequence)
+		case *ast.FunctionLiteral:
+			for _, p := range node.Parameters {
+				ident(p)
+			}
+			walk(node.Body)
+		case *ast.CallExpression:
+			walk(node.Function)
+			for _, a := range node.Arguments {
+				walk(a)
+			}
+		case *ast.InfixExpression:
+			walk(node.Left)
+			walk(node.Right)
+		}
+	}
+	walk(program)
+	return out
+}
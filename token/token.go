@@ -1,10 +1,41 @@
 package token
 
+import "fmt"
+
 type TokenType int
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // position of the first character of the token
+	End     Position // position immediately after the token
+}
+
+// Position describes a location in the source.
+// The zero value is an invalid position.
+type Position struct {
+	Filename string
+	Offset   int // byte offset, starting at 0
+	Line     int // line number, starting at 1
+	Column   int // column number, starting at 1 (byte count)
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String returns "file:line:col", "line:col" if there is no filename,
+// or "-" if the position is invalid.
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	s := fmt.Sprintf("%d:%d", p.Line, p.Column)
+	if p.Filename != "" {
+		s = p.Filename + ":" + s
+	}
+	return s
 }
 
 //go:generate stringer -type TokenType -linecomment token.go
@@ -14,6 +45,8 @@ const (
 	ILLEGAL           // ILLEGAL
 	EOF               // EOF
 
+	COMMENT // COMMENT
+
 	IDENT  // IDENT
 	INT    // INT
 	STRING // STRING
@@ -31,14 +64,19 @@ const (
 	GT     // >
 	LT_EQ  // <=
 	GT_EQ  // >=
+	AND    // &&
+	OR     // ||
 
 	COMMA     // ,
 	SEMICOLON // ;
+	COLON     // :
 
-	LPAREN // (
-	RPAREN // )
-	LBRACE // {
-	RBRACE // }
+	LPAREN   // (
+	RPAREN   // )
+	LBRACE   // {
+	RBRACE   // }
+	LBRACKET // [
+	RBRACKET // ]
 
 	FUNCTION // FUNCTION
 	LET      // LET
@@ -47,16 +85,26 @@ const (
 	IF       // IF
 	ELSE     // ELSE
 	RETURN   // RETURN
+	WHILE    // WHILE
+	FOR      // FOR
+	IN       // IN
+	BREAK    // BREAK
+	CONTINUE // CONTINUE
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 func LookupIdent(ident string) TokenType {
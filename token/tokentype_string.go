@@ -10,39 +10,50 @@ func _() {
 	var x [1]struct{}
 	_ = x[ILLEGAL-1]
 	_ = x[EOF-2]
-	_ = x[IDENT-3]
-	_ = x[INT-4]
-	_ = x[STRING-5]
-	_ = x[ASSIGN-6]
-	_ = x[PLUS-7]
-	_ = x[MINUS-8]
-	_ = x[BANG-9]
-	_ = x[ASTERISK-10]
-	_ = x[SLASH-11]
-	_ = x[EQ-12]
-	_ = x[NOT_EQ-13]
-	_ = x[LT-14]
-	_ = x[GT-15]
-	_ = x[LT_EQ-16]
-	_ = x[GT_EQ-17]
-	_ = x[COMMA-18]
-	_ = x[SEMICOLON-19]
-	_ = x[LPAREN-20]
-	_ = x[RPAREN-21]
-	_ = x[LBRACE-22]
-	_ = x[RBRACE-23]
-	_ = x[FUNCTION-24]
-	_ = x[LET-25]
-	_ = x[TRUE-26]
-	_ = x[FALSE-27]
-	_ = x[IF-28]
-	_ = x[ELSE-29]
-	_ = x[RETURN-30]
+	_ = x[COMMENT-3]
+	_ = x[IDENT-4]
+	_ = x[INT-5]
+	_ = x[STRING-6]
+	_ = x[ASSIGN-7]
+	_ = x[PLUS-8]
+	_ = x[MINUS-9]
+	_ = x[BANG-10]
+	_ = x[ASTERISK-11]
+	_ = x[SLASH-12]
+	_ = x[EQ-13]
+	_ = x[NOT_EQ-14]
+	_ = x[LT-15]
+	_ = x[GT-16]
+	_ = x[LT_EQ-17]
+	_ = x[GT_EQ-18]
+	_ = x[AND-19]
+	_ = x[OR-20]
+	_ = x[COMMA-21]
+	_ = x[SEMICOLON-22]
+	_ = x[COLON-23]
+	_ = x[LPAREN-24]
+	_ = x[RPAREN-25]
+	_ = x[LBRACE-26]
+	_ = x[RBRACE-27]
+	_ = x[LBRACKET-28]
+	_ = x[RBRACKET-29]
+	_ = x[FUNCTION-30]
+	_ = x[LET-31]
+	_ = x[TRUE-32]
+	_ = x[FALSE-33]
+	_ = x[IF-34]
+	_ = x[ELSE-35]
+	_ = x[RETURN-36]
+	_ = x[WHILE-37]
+	_ = x[FOR-38]
+	_ = x[IN-39]
+	_ = x[BREAK-40]
+	_ = x[CONTINUE-41]
 }
 
-const _TokenType_name = "ILLEGALEOFIDENTINTSTRING=+-!*/==!=<><=>=,;(){}FUNCTIONLETTRUEFALSEIFELSERETURN"
+const _TokenType_name = "ILLEGALEOFCOMMENTIDENTINTSTRING=+-!*/==!=<><=>=&&||,;:(){}[]FUNCTIONLETTRUEFALSEIFELSERETURNWHILEFORINBREAKCONTINUE"
 
-var _TokenType_index = [...]uint8{0, 7, 10, 15, 18, 24, 25, 26, 27, 28, 29, 30, 32, 34, 35, 36, 38, 40, 41, 42, 43, 44, 45, 46, 54, 57, 61, 66, 68, 72, 78}
+var _TokenType_index = [...]uint8{0, 7, 10, 17, 22, 25, 31, 32, 33, 34, 35, 36, 37, 39, 41, 42, 43, 45, 47, 49, 51, 52, 53, 54, 55, 56, 57, 58, 59, 60, 68, 71, 75, 80, 82, 86, 92, 97, 100, 102, 107, 115}
 
 func (i TokenType) String() string {
 	i -= 1
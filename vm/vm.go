@@ -0,0 +1,556 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pirosiki197/monkey/code"
+	"github.com/pirosiki197/monkey/compiler"
+	"github.com/pirosiki197/monkey/object"
+)
+
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+)
+
+// DefaultMaxCallDepth is the maximum call depth used unless
+// WithMaxCallDepth is given.
+const DefaultMaxCallDepth = 10000
+
+var (
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+	builtins    []*object.Builtin
+
+	// stack grows as needed; sp always points to the next free slot.
+	stack []object.Object
+	sp    int
+
+	frames []*Frame
+
+	maxCallDepth int
+	trace        io.Writer
+}
+
+// Option configures a VM.
+type Option func(*VM)
+
+// WithMaxCallDepth limits the depth of nested function calls.
+// A value of zero or less disables the limit.
+func WithMaxCallDepth(depth int) Option {
+	return func(vm *VM) {
+		vm.maxCallDepth = depth
+	}
+}
+
+// WithTrace logs each instruction to w before it is executed, together
+// with the call depth and the value on top of the stack.
+func WithTrace(w io.Writer) Option {
+	return func(vm *VM) {
+		vm.trace = w
+	}
+}
+
+// WithBuiltins makes the program call the builtins of r. Compiled code
+// refers to the standard builtins by index, so each is looked up in r by
+// its standard name; one missing from r is reported when it is used.
+func WithBuiltins(r *object.Registry) Option {
+	return func(vm *VM) {
+		for i, b := range object.StandardBuiltins() {
+			vm.builtins[i] = r.Lookup(b.Name)
+		}
+	}
+}
+
+func New(bytecode *compiler.Bytecode, opts ...Option) *VM {
+	return NewWithGlobalsStore(bytecode, make([]object.Object, GlobalsSize), opts...)
+}
+
+// NewWithGlobalsStore returns a VM using s for global bindings, so that
+// they survive across programs compiled with the same symbol table.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object, opts ...Option) *VM {
+	mainClosure := &object.Closure{Fn: bytecode.Main}
+
+	vm := &VM{
+		constants:    bytecode.Constants,
+		globals:      s,
+		globalNames:  bytecode.GlobalNames,
+		builtins:     object.StandardBuiltins(),
+		stack:        make([]object.Object, max(StackSize, bytecode.Main.NumLocals)),
+		frames:       []*Frame{NewFrame(mainClosure, 0)},
+		maxCallDepth: DefaultMaxCallDepth,
+	}
+	vm.sp = bytecode.Main.NumLocals
+	for _, opt := range opts {
+		opt(vm)
+	}
+	return vm
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[len(vm.frames)-1]
+}
+
+// Run executes the program and returns its result: the value of a final
+// expression statement or a top-level return, nil if there is none, or
+// the *object.Error that stopped execution.
+func (vm *VM) Run() (result object.Object) {
+	var frame *Frame
+	var ip int
+	defer func() {
+		if r := recover(); r != nil {
+			result = vm.errorAt(frame, ip, newError("internal error: %v", r))
+		}
+	}()
+
+	for {
+		frame = vm.currentFrame()
+		ins := frame.Instructions()
+		ip = frame.ip
+		op := code.Opcode(ins[ip])
+		frame.ip++
+
+		if vm.trace != nil {
+			vm.traceInstruction(frame, ip)
+		}
+
+		var err *object.Error
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+			code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+			code.OpLessEqual, code.OpGreaterEqual:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(binaryOperation(op, left, right))
+
+		case code.OpTrue:
+			vm.push(TRUE)
+		case code.OpFalse:
+			vm.push(FALSE)
+		case code.OpNull:
+			vm.push(NULL)
+
+		case code.OpBang:
+			vm.push(object.Prefix("!", vm.pop()))
+
+		case code.OpMinus:
+			err = vm.pushResult(object.Prefix("-", vm.pop()))
+
+		case code.OpJump:
+			frame.ip = int(code.ReadUint16(ins[ip+1:]))
+
+		case code.OpJumpNotTruthy:
+			frame.ip += 2
+			if !object.IsTruthy(vm.pop()) {
+				frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			}
+
+		case code.OpJumpFalsyOrPop, code.OpJumpTruthyOrPop:
+			frame.ip += 2
+			if object.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyOrPop) {
+				frame.ip = int(code.ReadUint16(ins[ip+1:]))
+			} else {
+				vm.pop()
+			}
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			val := vm.globals[globalIndex]
+			if val == nil {
+				err = newError("identifier not found: %s", vm.globalNames[globalIndex])
+				break
+			}
+			vm.push(val)
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			val := vm.pop()
+			if vm.globals[globalIndex] == nil {
+				err = newError("identifier not found: %s", vm.globalNames[globalIndex])
+				break
+			}
+			vm.globals[globalIndex] = val
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(vm.stack[frame.basePointer+int(localIndex)])
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(vm.stack[frame.basePointer+int(localIndex)].(*object.Cell).Value)
+
+		case code.OpSetCell:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.stack[frame.basePointer+int(localIndex)].(*object.Cell).Value = vm.pop()
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			val := frame.cl.Free[freeIndex].Value
+			if val == nil {
+				err = newError("identifier not found: %s", frame.cl.Fn.FreeNames[freeIndex])
+				break
+			}
+			vm.push(val)
+
+		case code.OpSetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			frame.cl.Free[freeIndex].Value = vm.pop()
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			builtin := vm.builtins[builtinIndex]
+			if builtin == nil {
+				err = newError("identifier not found: %s", object.StandardBuiltins()[builtinIndex].Name)
+				break
+			}
+			vm.push(builtin)
+
+		case code.OpEnterBlock:
+			blockIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			for _, slot := range frame.cl.Fn.Blocks[blockIndex] {
+				vm.stack[frame.basePointer+slot] = &object.Cell{}
+			}
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(vm.stack[frame.basePointer+int(localIndex)])
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			vm.push(frame.cl.Free[freeIndex])
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			frame.ip += 3
+			vm.pushClosure(int(constIndex), int(numFree))
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			err = vm.executeCall(int(numArgs))
+
+		case code.OpReturnValue, code.OpReturn:
+			var returnValue object.Object = NULL
+			if op == code.OpReturnValue {
+				returnValue = vm.pop()
+			}
+			if len(vm.frames) == 1 {
+				if op == code.OpReturn {
+					return nil
+				}
+				return returnValue
+			}
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.clear(frame.basePointer-1, vm.sp)
+			vm.sp = frame.basePointer - 1
+			vm.push(returnValue)
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp -= numElements
+			vm.push(&object.Array{Elements: elements})
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			var hash object.Object
+			hash, err = vm.buildHash(vm.sp-numElements, vm.sp)
+			if err == nil {
+				vm.sp -= numElements
+				vm.push(hash)
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(object.Index(left, index))
+
+		case code.OpIter:
+			slot := frame.basePointer + int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			iterable := vm.pop()
+			array, ok := iterable.(*object.Array)
+			if !ok {
+				err = newError("cannot iterate over %s", iterable.Type())
+				break
+			}
+			vm.stack[slot] = array
+			vm.stack[slot+1] = &object.Integer{Value: 0}
+
+		case code.OpIterNext:
+			slot := frame.basePointer + int(code.ReadUint8(ins[ip+1:]))
+			frame.ip += 3
+			array := vm.stack[slot].(*object.Array)
+			next := vm.stack[slot+1].(*object.Integer)
+			if next.Value >= int64(len(array.Elements)) {
+				frame.ip = int(code.ReadUint16(ins[ip+2:]))
+				break
+			}
+			vm.push(array.Elements[next.Value])
+			next.Value++
+
+		default:
+			err = newError("unknown opcode %d", op)
+		}
+
+		if err != nil {
+			return vm.errorAt(frame, ip, err)
+		}
+	}
+}
+
+func (vm *VM) traceInstruction(frame *Frame, ip int) {
+	ins, err := frame.Instructions().Decode(ip)
+	if err != nil {
+		fmt.Fprintf(vm.trace, "%3d %04d ERROR: %s\n", len(vm.frames)-1, ip, err)
+		return
+	}
+
+	top := "-"
+	if vm.sp > 0 && vm.stack[vm.sp-1] != nil {
+		top = vm.stack[vm.sp-1].Inspect()
+	}
+	fmt.Fprintf(vm.trace, "%3d %04d %-22s top=%s\n", len(vm.frames)-1, ip, ins, top)
+}
+
+// errorAt tags err with the position of the instruction at ip in frame,
+// unless it already has one.
+func (vm *VM) errorAt(frame *Frame, ip int, err *object.Error) *object.Error {
+	if !err.Pos.IsValid() && frame != nil {
+		err.Pos = frame.cl.Fn.PosAt(ip)
+	}
+	return err
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.grow(vm.sp + 1)
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+// pushResult pushes the result of an operation, unless it is an error.
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.stack[vm.sp-1] = nil
+	vm.sp--
+	return o
+}
+
+// grow makes room for at least n stack slots.
+func (vm *VM) grow(n int) {
+	if n <= len(vm.stack) {
+		return
+	}
+	stack := make([]object.Object, max(n, 2*len(vm.stack)))
+	copy(stack, vm.stack[:vm.sp])
+	vm.stack = stack
+}
+
+// clear drops the references held by the stack slots in [from, to).
+func (vm *VM) clear(from, to int) {
+	clear(vm.stack[from:to])
+}
+
+func (vm *VM) pushClosure(constIndex, numFree int) {
+	fn := vm.constants[constIndex].(*object.CompiledFunction)
+
+	free := make([]*object.Cell, numFree)
+	for i := range numFree {
+		free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+	}
+	vm.clear(vm.sp-numFree, vm.sp)
+	vm.sp -= numFree
+
+	vm.push(&object.Closure{Fn: fn, Free: free})
+}
+
+func (vm *VM) executeCall(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		result := callee.Call(args...)
+		vm.clear(vm.sp-numArgs-1, vm.sp)
+		vm.sp -= numArgs + 1
+		if err, ok := result.(*object.Error); ok {
+			return err
+		}
+		vm.push(result)
+		return nil
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) *object.Error {
+	fn := cl.Fn
+	if numArgs != fn.NumParameters {
+		if fn.Name != "" {
+			return newError("wrong length of arguments to %s: %d parameters but called with %d arguments",
+				fn.Name, fn.NumParameters, numArgs)
+		}
+		return newError("wrong length of arguments: %d parameters but called with %d arguments",
+			fn.NumParameters, numArgs)
+	}
+	if vm.maxCallDepth > 0 && len(vm.frames) > vm.maxCallDepth {
+		return newError("maximum call depth of %d exceeded", vm.maxCallDepth)
+	}
+
+	basePointer := vm.sp - numArgs
+	vm.grow(basePointer + fn.NumLocals + 1)
+	vm.clear(vm.sp, basePointer+fn.NumLocals)
+	for _, slot := range fn.CellParams {
+		vm.stack[basePointer+slot] = &object.Cell{Value: vm.stack[basePointer+slot]}
+	}
+
+	vm.frames = append(vm.frames, NewFrame(cl, basePointer))
+	vm.sp = basePointer + fn.NumLocals
+	return nil
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair, (endIndex-startIndex)/2)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// binaryOperation applies the operator of op to left and right. Integer
+// operands are handled by opcode; other operands, and division by zero,
+// go through object.Infix, which gives the evaluator's results and errors.
+func binaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	l, ok := left.(*object.Integer)
+	r, ok2 := right.(*object.Integer)
+	if !ok || !ok2 {
+		return object.Infix(binaryOperators[op], left, right)
+	}
+	switch op {
+	case code.OpAdd:
+		return &object.Integer{Value: l.Value + r.Value}
+	case code.OpSub:
+		return &object.Integer{Value: l.Value - r.Value}
+	case code.OpMul:
+		return &object.Integer{Value: l.Value * r.Value}
+	case code.OpDiv:
+		if r.Value != 0 {
+			return &object.Integer{Value: l.Value / r.Value}
+		}
+	case code.OpEqual:
+		return nativeBool(l.Value == r.Value)
+	case code.OpNotEqual:
+		return nativeBool(l.Value != r.Value)
+	case code.OpLessThan:
+		return nativeBool(l.Value < r.Value)
+	case code.OpGreaterThan:
+		return nativeBool(l.Value > r.Value)
+	case code.OpLessEqual:
+		return nativeBool(l.Value <= r.Value)
+	case code.OpGreaterEqual:
+		return nativeBool(l.Value >= r.Value)
+	}
+	return object.Infix(binaryOperators[op], left, right)
+}
+
+func nativeBool(b bool) *object.Boolean {
+	if b {
+		return TRUE
+	}
+	return FALSE
+}
+
+// binaryOperators maps the binary opcodes to the operators object.Infix
+// takes.
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
+func newError(format string, a ...any) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,239 @@
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pirosiki197/monkey/compiler"
+	"github.com/pirosiki197/monkey/lexer"
+	"github.com/pirosiki197/monkey/object"
+	"github.com/pirosiki197/monkey/parser"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"arithmetic", "(1 + 2) * 3 - 4 / 2", "7"},
+		{"strings", `"mon" + "key"`, "monkey"},
+		{"comparison", "1 <= 2 == true", "true"},
+		{"hash", `{"a": 1, 2: [3]}[2][0]`, "3"},
+		{"no_result", "let a = 1;", "<nil>"},
+		{"if_without_else", "if (false) { 1 }", "null"},
+		{"top_level_return", "return 1; 2", "1"},
+		{"use_before_let", "let f = fn() { g() }; let g = fn() { 5 }; f()", "5"},
+		{"undefined", "let f = fn() { g() }; f()", "ERROR: 1:16: identifier not found: g"},
+		{"error_position", "let a = 1;\nlet b = a + true;", "ERROR: 2:9: type mismatch: INTEGER + BOOLEAN"},
+		{"builtin_error", "len(1)", "ERROR: 1:1: argument to `len` not supported, got INTEGER"},
+		{"iterate", "let s = 0; for (x in [1, 2, 3]) { s = s + x } s", "6"},
+		{"iterate_error", "for (x in 1) { x }", "ERROR: 1:1: cannot iterate over INTEGER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := run(t, tt.input)
+			got := "<nil>"
+			if result != nil {
+				got = result.Inspect()
+			}
+			if got != tt.expected {
+				t.Errorf("wrong result. want=%q, got=%q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDeepRecursion(t *testing.T) {
+	input := `
+let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } };
+count(%d)`
+
+	testIntegerObject(t, run(t, fmt.Sprintf(input, 5000)), 5000)
+
+	result := run(t, fmt.Sprintf(input, DefaultMaxCallDepth+1))
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T (%+v)", result, result)
+	}
+	expected := fmt.Sprintf("maximum call depth of %d exceeded", DefaultMaxCallDepth)
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. want=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	symbolTable := compiler.NewSymbolTable()
+	for i, b := range object.StandardBuiltins() {
+		symbolTable.DefineBuiltin(i, b.Name)
+	}
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalsSize)
+
+	var result object.Object
+	for _, input := range []string{"let a = 1;", "let f = fn() { a + 1 };", "a = f(); a"} {
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := comp.Bytecode()
+		constants = bytecode.Constants
+		result = NewWithGlobalsStore(bytecode, globals).Run()
+	}
+
+	testIntegerObject(t, result, 2)
+}
+
+func TestBuiltins(t *testing.T) {
+	program := parser.New(lexer.New(`puts("hi"); len("ab")`)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var out strings.Builder
+	builtins := object.DefaultRegistry()
+	builtins.Add(object.Puts(&out))
+	testIntegerObject(t, New(comp.Bytecode(), WithBuiltins(builtins)).Run(), 2)
+	if out.String() != "hi\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	builtins.Remove("puts")
+	result := New(comp.Bytecode(), WithBuiltins(builtins)).Run()
+	if got := result.Inspect(); got != "ERROR: 1:1: identifier not found: puts" {
+		t.Errorf("removed builtin is available. got=%q", got)
+	}
+}
+
+// TestJumpOutOfExpression checks that break and continue inside a partly
+// evaluated expression leave nothing behind on the stack.
+func TestJumpOutOfExpression(t *testing.T) {
+	input := `
+let f = fn(a, b) { a };
+let i = 0;
+while (i < 100) {
+    i = i + 1;
+    let a = [1, 2, {"k": f(3, if (i < 50) { continue } else { break })}];
+}
+i`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	testIntegerObject(t, vm.Run(), 50)
+	if vm.sp != 1 {
+		t.Errorf("wrong stack height. want=1, got=%d", vm.sp)
+	}
+}
+
+// TestOperandLimits runs a program using the last global slot and
+// constant index, through the serialized bytecode.
+func TestOperandLimits(t *testing.T) {
+	var input strings.Builder
+	for i := range GlobalsSize - 1 {
+		fmt.Fprintf(&input, "let %s = %d;", name(i), i)
+	}
+	fmt.Fprintf(&input, "%s + 1", name(GlobalsSize-2))
+
+	comp := compiler.New()
+	if err := comp.Compile(parser.New(lexer.New(input.String())).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	data, err := comp.Bytecode().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bytecode compiler.Bytecode
+	if err := bytecode.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bytecode.Constants); n != 65536 {
+		t.Fatalf("wrong number of constants. want=65536, got=%d", n)
+	}
+
+	testIntegerObject(t, New(&bytecode).Run(), GlobalsSize-1)
+}
+
+// name returns a distinct identifier for each i, none of them a keyword.
+func name(i int) string {
+	s := ""
+	for {
+		s = string(rune('a'+i%26)) + s
+		if i /= 26; i == 0 {
+			return "v" + s
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	program := parser.New(lexer.New("let f = fn(x) { x * 2 }; f(3)")).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var trace strings.Builder
+	testIntegerObject(t, New(comp.Bytecode(), WithTrace(&trace)).Run(), 6)
+
+	// closures are inspected by address
+	got := regexp.MustCompile(`0x[0-9a-f]+`).ReplaceAllString(trace.String(), "ADDR")
+	expected := `  0 0000 OpClosure 1 0          top=-
+  0 0004 OpSetGlobal 0          top=Closure[ADDR]
+  0 0007 OpGetGlobal 0          top=-
+  0 0010 OpConstant 2           top=Closure[ADDR]
+  0 0013 OpCall 1               top=3
+  1 0000 OpGetLocal 0           top=3
+  1 0002 OpConstant 0           top=3
+  1 0005 OpMul                  top=2
+  1 0006 OpReturnValue          top=6
+  0 0015 OpReturnValue          top=6
+`
+	if got != expected {
+		t.Errorf("wrong trace.\nwant=\n%s\ngot=\n%s", expected, got)
+	}
+}
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	program := parser.New(lexer.New(input)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(comp.Bytecode()).Run()
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) {
+	t.Helper()
+
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Fatalf("object is not Integer. got=%T (%+v)", obj, obj)
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	program := parser.New(lexer.New(`
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(20)`)).ParseProgram()
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		b.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	for range b.N {
+		if result := New(bytecode).Run(); result.Type() == object.ERROR_OBJ {
+			b.Fatal(result.Inspect())
+		}
+	}
+}